@@ -0,0 +1,254 @@
+// Package ahocorasick finds many keywords in a text at once, using the
+// automaton of Aho and Corasick, "Efficient string matching: an aid to
+// bibliographic search".
+package ahocorasick
+
+import (
+	"context"
+	"dfa"
+	"fmt"
+	"queue"
+	"sort"
+	"unicode/utf8"
+)
+
+// Matcher is the Aho-Corasick automaton of a list of keywords. It is kept as
+// a DFA whose states are the nodes of the trie of the keywords, with the
+// failure links already followed, so that every character of the text costs
+// a single transition.
+type Matcher struct {
+	keywords []string
+	dfa      dfa.DFA
+	// depth[s] is the length in characters of the prefix trie node s stands for
+	depth []int
+	// ends[s] is the index of a keyword ending exactly at trie node s, or -1
+	ends []int
+	// the length in bytes of the longest keyword
+	maxLen int
+}
+
+// rough number of bytes used by every state of the automaton and by every
+// transition, the same as when turning an NFA into a DFA
+const (
+	stateCost      = 96
+	transitionCost = 48
+)
+
+// New builds the Aho-Corasick automaton of the given keywords. Empty
+// keywords are ignored.
+func New(keywords []string) *Matcher {
+	m, _ := NewContext(context.Background(), keywords, dfa.Limits{})
+	return m
+}
+
+// NewContext works like New, but gives up as soon as the context is done or
+// the automaton goes over MaxDFAStates or MaxMemory, returning either the
+// context's error, dfa.ErrTooManyStates or dfa.ErrTooMuchMemory.
+func NewContext(ctx context.Context, keywords []string, limits dfa.Limits) (*Matcher, error) {
+	m := &Matcher{keywords: keywords, dfa: dfa.New()}
+	d := &m.dfa
+	d.NumStates = 1
+	d.EntryState = 1
+	m.depth = []int{0, 0}
+	m.ends = []int{-1, -1}
+	memory := stateCost
+	// states and transitions are checked before they are added, so that a
+	// huge list of keywords stops as soon as it goes over the limits
+	reserve := func(states, transitions int) error {
+		if limits.MaxDFAStates > 0 && d.NumStates+states > limits.MaxDFAStates {
+			return fmt.Errorf("%w: the automaton needs more than %d states",
+				dfa.ErrTooManyStates, limits.MaxDFAStates)
+		}
+		cost := states*stateCost + transitions*transitionCost
+		if limits.MaxMemory > 0 && memory+cost > limits.MaxMemory {
+			return fmt.Errorf("%w: the automaton needs more than %d bytes",
+				dfa.ErrTooMuchMemory, limits.MaxMemory)
+		}
+		memory += cost
+		return nil
+	}
+	alphabet := make(map[rune]bool)
+	for i, keyword := range keywords {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if keyword == "" {
+			continue
+		}
+		if len(keyword) > m.maxLen {
+			m.maxLen = len(keyword)
+		}
+		state := d.EntryState
+		for _, char := range keyword {
+			alphabet[char] = true
+			if _, ok := d.Graph[state]; !ok {
+				d.Graph[state] = make(map[rune]int)
+			}
+			next, ok := d.Graph[state][char]
+			if !ok {
+				if err := reserve(1, 1); err != nil {
+					return nil, err
+				}
+				d.NumStates++
+				next = d.NumStates
+				d.Graph[state][char] = next
+				m.depth = append(m.depth, m.depth[state]+1)
+				m.ends = append(m.ends, -1)
+			}
+			state = next
+		}
+		if m.ends[state] < 0 {
+			m.ends[state] = i
+		}
+		d.Labels[state] = append(d.Labels[state], i)
+	}
+
+	// the failure link of a node leads to the longest proper suffix of its
+	// prefix that is also in the trie. Going through the trie breadth first,
+	// the failure links of shorter prefixes are known, along with all their
+	// transitions.
+	fail := make([]int, d.NumStates+1)
+	if _, ok := d.Graph[d.EntryState]; !ok {
+		d.Graph[d.EntryState] = make(map[rune]int)
+	}
+	alphabet[dfa.Other] = true
+	d.Alphabet = make([]rune, 0, len(alphabet))
+	for char, _ := range alphabet {
+		d.Alphabet = append(d.Alphabet, char)
+		if _, ok := d.Graph[d.EntryState][char]; !ok {
+			d.Graph[d.EntryState][char] = d.EntryState
+		}
+	}
+	sort.Slice(d.Alphabet, func(i, j int) bool { return d.Alphabet[i] < d.Alphabet[j] })
+	q := queue.New(d.NumStates)
+	q.Push(d.EntryState)
+	for !q.Empty() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		state, _ := q.Pop()
+		children := make([]int, 0, len(d.Graph[state]))
+		for char, next := range d.Graph[state] {
+			if m.depth[next] != m.depth[state]+1 {
+				continue
+			}
+			children = append(children, next)
+			if state == d.EntryState {
+				fail[next] = d.EntryState
+			} else {
+				fail[next] = d.Graph[fail[state]][char]
+			}
+		}
+		if state != d.EntryState {
+			if _, ok := d.Graph[state]; !ok {
+				d.Graph[state] = make(map[rune]int)
+			}
+			// the transitions of the failure link that the node doesn't
+			// have are added to it
+			if err := reserve(0, len(d.Graph[fail[state]])-len(children)); err != nil {
+				return nil, err
+			}
+			for char, next := range d.Graph[fail[state]] {
+				if _, ok := d.Graph[state][char]; !ok {
+					d.Graph[state][char] = next
+				}
+			}
+			if len(d.Labels[fail[state]]) > 0 {
+				d.Labels[state] = append(d.Labels[state], d.Labels[fail[state]]...)
+				sort.Ints(d.Labels[state])
+			}
+		}
+		for _, next := range children {
+			q.Push(next)
+		}
+	}
+
+	for state := 1; state <= d.NumStates; state++ {
+		d.NumTransitions += len(d.Graph[state])
+		if len(d.Labels[state]) > 0 {
+			d.FinalStates = append(d.FinalStates, state)
+		}
+	}
+	return m, nil
+}
+
+// DFA returns the automaton of the keywords. It accepts the words that end
+// with one of them, and the final states are labelled with the indices of
+// the keywords they end.
+func (m *Matcher) DFA() dfa.DFA {
+	return m.dfa
+}
+
+// Keywords returns the keywords the Matcher was built from
+func (m *Matcher) Keywords() []string {
+	return m.keywords
+}
+
+// Match checks whether the word is one of the keywords
+func (m *Matcher) Match(word string) bool {
+	state, ok := m.walk(word)
+	return ok && m.ends[state] >= 0
+}
+
+// walk follows the trie along the word, returning false if it falls out
+// of it
+func (m *Matcher) walk(word string) (int, bool) {
+	state := m.dfa.EntryState
+	for _, char := range word {
+		next, ok := m.dfa.Graph[state][char]
+		if !ok || m.depth[next] != m.depth[state]+1 {
+			return 0, false
+		}
+		state = next
+	}
+	return state, true
+}
+
+// Find returns the byte offsets [start, end] of the leftmost-longest
+// occurrence of a keyword in text[from:], or nil if there is none.
+func (m *Matcher) Find(text string, from int) []int {
+	state := m.dfa.EntryState
+	for i, char := range text[from:] {
+		state, _ = m.dfa.Next(state, char)
+		if len(m.dfa.Labels[state]) == 0 {
+			continue
+		}
+		// this is the occurrence that ends first. Any occurrence starting
+		// further left has to end later, so it can't start more than maxLen
+		// bytes before this one ends.
+		_, size := utf8.DecodeRuneInString(text[from+i:])
+		end := from + i + size
+		start := end - m.maxLen
+		if start < from {
+			start = from
+		}
+		for ; start < end; start++ {
+			if !utf8.RuneStart(text[start]) {
+				continue
+			}
+			if longest := m.longest(text, start); longest >= 0 {
+				return []int{start, longest}
+			}
+		}
+	}
+	return nil
+}
+
+// longest returns the end of the longest keyword starting at the given
+// offset of the text, or -1 if there is none
+func (m *Matcher) longest(text string, start int) int {
+	res := -1
+	state := m.dfa.EntryState
+	for i, char := range text[start:] {
+		next, ok := m.dfa.Graph[state][char]
+		if !ok || m.depth[next] != m.depth[state]+1 {
+			break
+		}
+		state = next
+		if m.ends[state] >= 0 {
+			_, size := utf8.DecodeRuneInString(text[start+i:])
+			res = start + i + size
+		}
+	}
+	return res
+}
@@ -0,0 +1,136 @@
+package ahocorasick
+
+import (
+	"context"
+	"dfa"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// naiveFind looks for the leftmost-longest keyword by trying every offset
+func naiveFind(keywords []string, text string, from int) []int {
+	for start := from; start < len(text); start++ {
+		best := -1
+		for _, keyword := range keywords {
+			if keyword != "" && strings.HasPrefix(text[start:], keyword) && start+len(keyword) > best {
+				best = start + len(keyword)
+			}
+		}
+		if best >= 0 {
+			return []int{start, best}
+		}
+	}
+	return nil
+}
+
+func TestFind(t *testing.T) {
+	type Test struct {
+		Keywords []string
+		Text     string
+	}
+	tests := []Test{
+		Test{[]string{"he", "she", "his", "hers"}, "ushers"},
+		Test{[]string{"abcd", "bc"}, "xabcd"},
+		Test{[]string{"abcd", "bc"}, "xabce"},
+		Test{[]string{"a", "aa", "aaa"}, "baaaab"},
+		Test{[]string{"ba", "aab"}, "aaba"},
+		Test{[]string{"foo", "bar"}, "nothing here"},
+		Test{[]string{"ăș", "șt"}, "aăștb"},
+		Test{[]string{"", "x"}, "abx"},
+	}
+	for _, test := range tests {
+		m := New(test.Keywords)
+		for from := 0; from <= len(test.Text); from++ {
+			expected := naiveFind(test.Keywords, test.Text, from)
+			if res := m.Find(test.Text, from); !reflect.DeepEqual(res, expected) {
+				t.Errorf("%v: Find(%q, %d) gives %v, expected %v", test.Keywords, test.Text, from, res, expected)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	m := New([]string{"he", "she", "his", "hers"})
+	for _, word := range []string{"he", "she", "his", "hers"} {
+		if !m.Match(word) {
+			t.Errorf("%q should match", word)
+		}
+	}
+	for _, word := range []string{"", "h", "her", "ushers", "shers"} {
+		if m.Match(word) {
+			t.Errorf("%q shouldn't match", word)
+		}
+	}
+}
+
+func TestDFA(t *testing.T) {
+	keywords := []string{"he", "she", "his", "hers"}
+	d := New(keywords).DFA()
+	tests := map[string][]int{
+		"hshe":  []int{0, 1},
+		"hsher": nil,
+		"ushe":  []int{0, 1},
+		"usher": nil,
+		"hers":  []int{3},
+		"his":   []int{2},
+		"hhe":   []int{0},
+	}
+	for word, expected := range tests {
+		state := d.EntryState
+		for _, char := range word {
+			state, _ = d.Next(state, char)
+		}
+		if !reflect.DeepEqual(d.Labels[state], expected) {
+			t.Errorf("%q ends with %v, expected %v", word, d.Labels[state], expected)
+		}
+		if d.Check(word) != (expected != nil) {
+			t.Errorf("Check(%q) gives %v", word, d.Check(word))
+		}
+	}
+	// the root, he, h, s, sh, she, hi, his, her, hers
+	if d.NumStates != 10 {
+		t.Errorf("Expected 10 states, got %d", d.NumStates)
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	keywords := []string{"he", "she", "his", "hers"}
+	// the automaton has 10 states
+	if _, err := NewContext(context.Background(), keywords, dfa.Limits{MaxDFAStates: 10}); err != nil {
+		t.Errorf("10 states should be enough, got %v", err)
+	}
+	if _, err := NewContext(context.Background(), keywords, dfa.Limits{MaxDFAStates: 9}); !errors.Is(err, dfa.ErrTooManyStates) {
+		t.Errorf("Expected ErrTooManyStates, got %v", err)
+	}
+	if _, err := NewContext(context.Background(), keywords, dfa.Limits{MaxMemory: 500}); !errors.Is(err, dfa.ErrTooMuchMemory) {
+		t.Errorf("Expected ErrTooMuchMemory, got %v", err)
+	}
+	// the trie of many keywords stops growing at the limit
+	many := make([]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		many = append(many, strings.Repeat("ab", i%50)+string(rune('a'+i%26)))
+	}
+	if _, err := NewContext(context.Background(), many, dfa.Limits{MaxDFAStates: 100}); !errors.Is(err, dfa.ErrTooManyStates) {
+		t.Errorf("Expected ErrTooManyStates, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewContext(ctx, keywords, dfa.Limits{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func BenchmarkFind(b *testing.B) {
+	keywords := make([]string, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		keywords = append(keywords, strings.Repeat(string(rune('a'+i%26)), 1+i%7)+string(rune('a'+i/26%26))+"z")
+	}
+	m := New(keywords)
+	text := strings.Repeat("the quick brown fox jumps over the sleepy dog ", 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Find(text, 0)
+	}
+}
@@ -0,0 +1,161 @@
+package dfa
+
+import (
+	"math"
+	"sort"
+)
+
+// Other stands, in the alphabet and the transitions of a DFA, for all the
+// characters that aren't in its alphabet. It's only taken into account if
+// it's in the alphabet.
+const Other rune = -1
+
+// Range is an interval of characters, both ends included
+type Range struct {
+	Lo, Hi rune
+}
+
+// Next returns the state reached from the given one by reading a
+// character, which takes the transition on the symbol of the alphabet
+// whose range holds the character, or on Other if there is none. ok is
+// false if there is no such transition.
+func (d *DFA) Next(state int, char rune) (next int, ok bool) {
+	if next, ok = d.Graph[state][char]; ok {
+		return next, true
+	}
+	if symbol := Symbol(d.Alphabet, d.Ends, char); symbol != char {
+		next, ok = d.Graph[state][symbol]
+	}
+	return next, ok
+}
+
+// InAlphabet checks whether the character is one of those listed in the
+// alphabet, alone or in a range. With an implicit alphabet, it checks
+// whether there is a transition on the character.
+func (d *DFA) InAlphabet(char rune) bool {
+	alphabet := d.Symbols()
+	i := sort.Search(len(alphabet), func(i int) bool { return alphabet[i] > char }) - 1
+	return i >= 0 && alphabet[i] != Other && char <= end(d.Ends, alphabet[i])
+}
+
+// Symbols returns the alphabet of the DFA, in increasing order: Alphabet if
+// it's set, or else the characters on the transitions.
+func (d *DFA) Symbols() []rune {
+	if d.Alphabet != nil {
+		return d.Alphabet
+	}
+	seen := make(map[rune]bool)
+	res := make([]rune, 0)
+	for node := 1; node <= d.NumStates; node++ {
+		for character, _ := range d.Graph[node] {
+			if !seen[character] {
+				seen[character] = true
+				res = append(res, character)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Symbol returns the symbol of the alphabet that stands for the character:
+// the one whose range holds it, or else Other if the alphabet has it. The
+// character itself is returned if there is neither, which is always the
+// case with an implicit alphabet.
+func Symbol(alphabet []rune, ends map[rune]rune, char rune) rune {
+	i := sort.Search(len(alphabet), func(i int) bool { return alphabet[i] > char }) - 1
+	if i >= 0 && alphabet[i] != Other && char <= end(ends, alphabet[i]) {
+		return alphabet[i]
+	}
+	if hasOther(alphabet) {
+		return Other
+	}
+	return char
+}
+
+func hasOther(alphabet []rune) bool {
+	return len(alphabet) > 0 && alphabet[0] == Other
+}
+
+// end returns the last character of the range of a symbol
+func end(ends map[rune]rune, symbol rune) rune {
+	if hi, ok := ends[symbol]; ok {
+		return hi
+	}
+	return symbol
+}
+
+// Ranges returns the ranges of characters the symbols of the alphabet
+// stand for, leaving out Other
+func Ranges(alphabet []rune, ends map[rune]rune) []Range {
+	res := make([]Range, 0, len(alphabet))
+	for _, symbol := range alphabet {
+		if symbol != Other {
+			res = append(res, Range{symbol, end(ends, symbol)})
+		}
+	}
+	return res
+}
+
+// Refine splits the characters of the ranges into the largest intervals
+// that are either inside or outside of each range, and returns them as an
+// alphabet: every interval becomes a symbol, its first character, and ends
+// gives the last character of those that hold more than one. Other comes
+// first if other is set. Every symbol of an alphabet whose ranges are among
+// the given ones stands then for whole symbols of the result.
+func Refine(ranges []Range, other bool) (alphabet []rune, ends map[rune]rune) {
+	// the number of ranges each boundary starts minus the number it ends
+	delta := make(map[rune]int)
+	for _, r := range ranges {
+		if r.Lo > r.Hi || r.Lo < 0 {
+			continue
+		}
+		delta[r.Lo]++
+		// a range ending with the last rune doesn't need a boundary after
+		// it, which would overflow
+		if r.Hi < math.MaxInt32 {
+			delta[r.Hi+1]--
+		}
+	}
+	bounds := make([]rune, 0, len(delta))
+	for bound, _ := range delta {
+		bounds = append(bounds, bound)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	alphabet = make([]rune, 0, len(bounds)+1)
+	if other {
+		alphabet = append(alphabet, Other)
+	}
+	covered := 0
+	for i, bound := range bounds {
+		covered += delta[bound]
+		if covered == 0 {
+			continue
+		}
+		alphabet = append(alphabet, bound)
+		hi := rune(math.MaxInt32)
+		if i+1 < len(bounds) {
+			hi = bounds[i+1] - 1
+		}
+		if hi > bound {
+			if ends == nil {
+				ends = make(map[rune]rune)
+			}
+			ends[bound] = hi
+		}
+	}
+	return alphabet, ends
+}
+
+// copyEnds returns a copy of the ends of an alphabet
+func copyEnds(ends map[rune]rune) map[rune]rune {
+	if ends == nil {
+		return nil
+	}
+	res := make(map[rune]rune, len(ends))
+	for symbol, hi := range ends {
+		res[symbol] = hi
+	}
+	return res
+}
@@ -0,0 +1,176 @@
+package dfa
+
+import (
+	"queue"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MinimizeBrzozowski minimizes the DFA by reversing and determinizing it
+// twice (see Brzozowski, "Canonical regular expressions and minimal state
+// graphs for definite events"). It can take exponential time, but it
+// doesn't share any code with Minimize, so the two can check each other.
+// Labels are dropped.
+func (d *DFA) MinimizeBrzozowski() {
+	res := d.reverseDeterminize()
+	*d = res.reverseDeterminize()
+}
+
+// reverseDeterminize returns a DFA accepting the reversed words of the DFA,
+// built by the subset construction over the reversed transitions. Only the
+// reachable, non-empty subsets become states.
+func (d *DFA) reverseDeterminize() DFA {
+	reverse := make(map[int]map[rune][]int)
+	for node := 1; node <= d.NumStates; node++ {
+		for character, neighbour := range d.Graph[node] {
+			if _, ok := reverse[neighbour]; !ok {
+				reverse[neighbour] = make(map[rune][]int)
+			}
+			reverse[neighbour][character] = append(reverse[neighbour][character], node)
+		}
+	}
+
+	res := New()
+	res.Alphabet, res.Ends = d.Alphabet, d.Ends
+	sets := make([][]int, 1)
+	ids := make(map[string]int)
+	q := queue.New(d.NumStates + 1)
+	add := func(set []int) int {
+		key := subsetKey(set)
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		sets = append(sets, set)
+		res.NumStates++
+		ids[key] = res.NumStates
+		q.Push(res.NumStates)
+		return res.NumStates
+	}
+
+	entry := uniqueSorted(d.FinalStates)
+	if len(entry) == 0 {
+		return res
+	}
+	res.EntryState = add(entry)
+	for !q.Empty() {
+		id, _ := q.Pop()
+		set := sets[id]
+		targets := make(map[rune][]int)
+		for _, node := range set {
+			if node == d.EntryState {
+				res.FinalStates = append(res.FinalStates, id)
+			}
+			for character, neighbours := range reverse[node] {
+				targets[character] = append(targets[character], neighbours...)
+			}
+		}
+		characters := make([]rune, 0, len(targets))
+		for character, _ := range targets {
+			characters = append(characters, character)
+		}
+		sort.Slice(characters, func(i, j int) bool { return characters[i] < characters[j] })
+		for _, character := range characters {
+			next := add(uniqueSorted(targets[character]))
+			if _, ok := res.Graph[id]; !ok {
+				res.Graph[id] = make(map[rune]int)
+			}
+			res.Graph[id][character] = next
+			res.NumTransitions++
+		}
+	}
+	sort.Ints(res.FinalStates)
+	return res
+}
+
+// uniqueSorted returns the distinct states of the list, in increasing order
+func uniqueSorted(states []int) []int {
+	res := make([]int, len(states))
+	copy(res, states)
+	sort.Ints(res)
+	unique := res[:0]
+	for i, node := range res {
+		if i == 0 || node != res[i-1] {
+			unique = append(unique, node)
+		}
+	}
+	return unique
+}
+
+func subsetKey(set []int) string {
+	var b strings.Builder
+	for i, node := range set {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(node))
+	}
+	return b.String()
+}
+
+// Isomorphic checks whether the two DFAs are the same up to the numbering
+// of their states: the states reachable from the entry states can be
+// paired up so that transitions, final states and labels match. Their
+// alphabets must be the same too.
+func Isomorphic(a, b DFA) bool {
+	if (a.EntryState == 0) != (b.EntryState == 0) || !sameAlphabet(a, b) {
+		return false
+	}
+	if a.EntryState == 0 {
+		return true
+	}
+	a_final, b_final := make(map[int]bool), make(map[int]bool)
+	for _, node := range a.FinalStates {
+		a_final[node] = true
+	}
+	for _, node := range b.FinalStates {
+		b_final[node] = true
+	}
+	to_b, to_a := make(map[int]int), make(map[int]int)
+	to_b[a.EntryState], to_a[b.EntryState] = b.EntryState, a.EntryState
+	q := queue.New(a.NumStates + 1)
+	q.Push(a.EntryState)
+	for !q.Empty() {
+		x, _ := q.Pop()
+		y := to_b[x]
+		if a_final[x] != b_final[y] || len(a.Graph[x]) != len(b.Graph[y]) {
+			return false
+		}
+		if a_final[x] && subsetKey(a.Labels[x]) != subsetKey(b.Labels[y]) {
+			return false
+		}
+		for character, next_a := range a.Graph[x] {
+			next_b, ok := b.Graph[y][character]
+			if !ok {
+				return false
+			}
+			paired_b, seen_a := to_b[next_a]
+			paired_a, seen_b := to_a[next_b]
+			if seen_a != seen_b || (seen_a && (paired_b != next_b || paired_a != next_a)) {
+				return false
+			}
+			if !seen_a {
+				to_b[next_a], to_a[next_b] = next_b, next_a
+				q.Push(next_a)
+			}
+		}
+	}
+	return true
+}
+
+func sameAlphabet(a, b DFA) bool {
+	if len(a.Alphabet) != len(b.Alphabet) || len(a.Ends) != len(b.Ends) {
+		return false
+	}
+	for i := range a.Alphabet {
+		if a.Alphabet[i] != b.Alphabet[i] {
+			return false
+		}
+	}
+	for symbol, hi := range a.Ends {
+		if other, ok := b.Ends[symbol]; !ok || other != hi {
+			return false
+		}
+	}
+	return true
+}
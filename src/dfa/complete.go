@@ -0,0 +1,70 @@
+package dfa
+
+// IsComplete checks whether every state has a transition on every
+// character of the alphabet
+func (d *DFA) IsComplete() bool {
+	if d.EntryState == 0 {
+		return false
+	}
+	alphabet := d.Symbols()
+	for node := 1; node <= d.NumStates; node++ {
+		for _, character := range alphabet {
+			if _, ok := d.Graph[node][character]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Complete adds the missing transitions of the DFA, all leading to a new
+// sink state, which isn't final and only leads to itself. Afterwards, every
+// state has a transition on every character of the alphabet, which is
+// fixed first if it was implicit. A DFA without states gets the sink as its
+// entry state. Minimize removes the sink again.
+func (d *DFA) Complete() {
+	d.Alphabet = d.Symbols()
+	if d.IsComplete() {
+		return
+	}
+	d.NumStates++
+	sink := d.NumStates
+	// Graph may still have transitions of states removed by MinimizeMoore
+	delete(d.Graph, sink)
+	if d.EntryState == 0 {
+		d.EntryState = sink
+	}
+	for node := 1; node <= d.NumStates; node++ {
+		for _, character := range d.Alphabet {
+			if _, ok := d.Graph[node][character]; ok {
+				continue
+			}
+			if _, ok := d.Graph[node]; !ok {
+				d.Graph[node] = make(map[rune]int)
+			}
+			d.Graph[node][character] = sink
+			d.NumTransitions++
+		}
+	}
+}
+
+// Complement returns a DFA accepting the words over the alphabet that the
+// DFA rejects. It's built by completing a copy of the DFA and swapping its
+// final and non-final states, so the words it accepts may use any
+// character only if the alphabet has Other. Labels are dropped.
+func (d *DFA) Complement() DFA {
+	res := Copy(*d)
+	res.Complete()
+	is_final := make([]bool, res.NumStates+1)
+	for _, node := range res.FinalStates {
+		is_final[node] = true
+	}
+	res.FinalStates = make([]int, 0, res.NumStates)
+	for node := 1; node <= res.NumStates; node++ {
+		if !is_final[node] {
+			res.FinalStates = append(res.FinalStates, node)
+		}
+	}
+	res.Labels = make(map[int][]int)
+	return res
+}
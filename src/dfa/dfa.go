@@ -1,9 +1,12 @@
 package dfa
 
 import (
+	"context"
 	"fmt"
+	"gnfa"
 	"io"
 	"queue"
+	"sort"
 )
 
 // DFA represents a Deterministic Finite Automaton
@@ -20,14 +23,31 @@ type DFA struct {
 	// DFA Final states
 	FinalStates []int
 
-	// The actual DFA is kept as a Graph
-	Graph map[int]map[rune][]int
+	// The actual DFA is kept as a Graph: Graph[state][character] is the
+	// state reached by reading the character, if there is one
+	Graph map[int]map[rune]int
+
+	// Labels of the final states, in increasing order, such as the rules
+	// they accept on behalf of. Final states with different labels are
+	// never merged by Minimize.
+	Labels map[int][]int
+
+	// Alphabet lists, in increasing order, the characters the DFA reads,
+	// or the first ones of the ranges it reads, with Other first if it
+	// reads any character. If it's nil, the
+	// alphabet is made of the characters on the transitions.
+	Alphabet []rune
+
+	// Ends gives, for the characters of the alphabet that stand for a
+	// range of characters, the last one of the range. The transitions on
+	// such a character are taken by every character of its range.
+	Ends map[rune]rune
 }
 
 // New is an mpty constructor for a DFA. Returns a null DFA (zero states,
 // zero transitions)
 func New() DFA {
-	return DFA{0, 0, 0, make([]int, 0), make(map[int]map[rune][]int)}
+	return DFA{0, 0, 0, make([]int, 0), make(map[int]map[rune]int), make(map[int][]int), nil, nil}
 }
 
 // Process reads a DFA from a Reader. The DFA should look like this:
@@ -49,18 +69,19 @@ func (d *DFA) Process(r io.Reader) {
 		}
 		_, ok := d.Graph[a]
 		if !ok {
-			d.Graph[a] = make(map[rune][]int)
+			d.Graph[a] = make(map[rune]int)
 		}
-		if _, ok := d.Graph[a][c]; !ok {
-			d.Graph[a][c] = []int{b}
-		} else {
-			d.Graph[a][c] = append(d.Graph[a][c], b)
+		if old, ok := d.Graph[a][c]; ok && old != b {
+			panic(fmt.Errorf("State %d has two transitions on %c", a, c))
 		}
+		d.Graph[a][c] = b
 	}
 
-	fmt.Fscanf(r, "%d %d\n", &d.EntryState, &NumStates)
+	// the entry state and the final states may be split across lines, so
+	// read them without caring about newlines
+	fmt.Fscan(r, &d.EntryState, &NumStates)
 	for i := 0; i < NumStates; i++ {
-		fmt.Fscanf(r, "%d\n", &state)
+		fmt.Fscan(r, &state)
 		d.FinalStates = append(d.FinalStates, state)
 	}
 }
@@ -69,11 +90,11 @@ func (d *DFA) Process(r io.Reader) {
 func (d *DFA) Check(word string) bool {
 	state := d.EntryState
 	for _, char := range word {
-		nodes, ok := d.Graph[state][char]
+		next, ok := d.Next(state, char)
 		if !ok {
 			return false
 		}
-		state = nodes[0]
+		state = next
 	}
 	return d.IsFinal(state)
 }
@@ -82,10 +103,8 @@ func (d *DFA) Check(word string) bool {
 func (d *DFA) Print(w io.Writer) {
 	fmt.Fprintf(w, "%d %d\n", d.NumStates, d.NumTransitions)
 	for i := 1; i <= d.NumStates; i++ {
-		for character, nodes := range d.Graph[i] {
-			for _, neighbour := range nodes {
-				fmt.Fprintf(w, "%d %d %c\n", i, neighbour, character)
-			}
+		for character, neighbour := range d.Graph[i] {
+			fmt.Fprintf(w, "%d %d %c\n", i, neighbour, character)
 		}
 	}
 	fmt.Fprintf(w, "%d\n", d.EntryState)
@@ -118,54 +137,118 @@ func (d *DFA) IsReverseUnreachable(state int) bool {
 	q.Push(state)
 	for !q.Empty() {
 		node, _ := q.Pop()
-		for _, neighbours := range d.Graph[node] {
-			if viz[neighbours[0]] {
+		for _, neighbour := range d.Graph[node] {
+			if viz[neighbour] {
 				continue
 			}
-			if d.IsFinal(neighbours[0]) {
+			if d.IsFinal(neighbour) {
 				return false
 			}
-			viz[neighbours[0]] = true
-			q.Push(neighbours[0])
+			viz[neighbour] = true
+			q.Push(neighbour)
 		}
 	}
 	return true
 }
 
-func nodes_match(graph map[int]map[rune][]int, is_final []bool, node1, node2 int) bool {
+func nodes_match(graph map[int]map[rune]int, kind []string, node1, node2 int) bool {
 	// perform a simultaneous BFS from the two nodes; if there is a string
 	// that matches from node1 but not from node2 or the other way around,
 	// the two nodes don't match
-	// q := queue.New(1)
-	if len(graph[node1]) != len(graph[node2]) {
-		return false
-	}
-	for character, neighbours := range graph[node1] {
-		if neighbours2, ok := graph[node2][character]; !ok || is_final[neighbours[0]] != is_final[neighbours2[0]] {
+	q1 := queue.New(1)
+	q2 := queue.New(1)
+	viz := make(map[[2]int]bool)
+	q1.Push(node1)
+	q2.Push(node2)
+	viz[[2]int{node1, node2}] = true
+	for !q1.Empty() {
+		a, _ := q1.Pop()
+		b, _ := q2.Pop()
+		if kind[a] != kind[b] || len(graph[a]) != len(graph[b]) {
 			return false
 		}
+		for character, neighbour := range graph[a] {
+			neighbour2, ok := graph[b][character]
+			if !ok {
+				return false
+			}
+			pair := [2]int{neighbour, neighbour2}
+			if !viz[pair] {
+				viz[pair] = true
+				q1.Push(pair[0])
+				q2.Push(pair[1])
+			}
+		}
 	}
 	return true
 }
 
+// Copy returns a DFA that shares no memory with the original one
+func Copy(d DFA) DFA {
+	res := New()
+	res.NumStates = d.NumStates
+	res.NumTransitions = d.NumTransitions
+	res.EntryState = d.EntryState
+	res.FinalStates = make([]int, len(d.FinalStates))
+	copy(res.FinalStates, d.FinalStates)
+	for node, _ := range d.Graph {
+		res.Graph[node] = make(map[rune]int)
+		for character, neighbour := range d.Graph[node] {
+			res.Graph[node][character] = neighbour
+		}
+	}
+	for node, labels := range d.Labels {
+		res.Labels[node] = make([]int, len(labels))
+		copy(res.Labels[node], labels)
+	}
+	if d.Alphabet != nil {
+		res.Alphabet = make([]rune, len(d.Alphabet))
+		copy(res.Alphabet, d.Alphabet)
+	}
+	res.Ends = copyEnds(d.Ends)
+	return res
+}
+
 // Minimize simplifies the DFA, by removing unnecessary states and merging
-// states that can be merged
+// states that can be merged. It uses Hopcroft's algorithm, which takes
+// O(n log n) time for n states and a fixed alphabet.
 func (d *DFA) Minimize() {
+	d.hopcroft(context.Background())
+}
+
+// MinimizeContext works like Minimize, but gives up as soon as the context
+// is done, returning its error and leaving the DFA unchanged.
+func (d *DFA) MinimizeContext(ctx context.Context) error {
+	res := Copy(*d)
+	if err := res.hopcroft(ctx); err != nil {
+		return err
+	}
+	*d = res
+	return nil
+}
+
+// MinimizeMoore works like Minimize, but merges states pairwise until no
+// more can be merged, in the manner of Moore's algorithm. It takes
+// quadratic time or worse, and is kept to cross-check Minimize.
+func (d *DFA) MinimizeMoore() {
+	d.moore(context.Background())
+}
+
+func (d *DFA) moore(ctx context.Context) error {
 	// find unreachable and reverse-unreachable states
 	q := queue.New(d.NumStates)
 	to_remove := make([]bool, d.NumStates+1)
 	viz := make(map[int]bool)
 	nodes_removed := 0
-	transitions_removed := 0
 	q.Push(d.EntryState)
 	viz[d.EntryState] = true
 	for !q.Empty() {
 		node, _ := q.Pop()
-		for _, neighbours := range d.Graph[node] {
-			ok, _ := viz[neighbours[0]]
+		for _, neighbour := range d.Graph[node] {
+			ok, _ := viz[neighbour]
 			if !ok {
-				viz[neighbours[0]] = true
-				q.Push(neighbours[0])
+				viz[neighbour] = true
+				q.Push(neighbour)
 			}
 		}
 	}
@@ -177,14 +260,26 @@ func (d *DFA) Minimize() {
 		}
 	}
 
+	// transitions to removed states can't lead to a final state, and would
+	// keep states that only differ by them apart
+	for node, _ := range d.Graph {
+		for character, neighbour := range d.Graph[node] {
+			if neighbour < 1 || neighbour > d.NumStates || to_remove[neighbour] {
+				delete(d.Graph[node], character)
+			}
+		}
+	}
+
 	// Moore's Algorithm
 	// See http://en.wikipedia.org/wiki/DFA_minimization#Moore.27s_algorithm
-	is_final := make([]bool, d.NumStates+1)
+	// states can only be merged if they are of the same kind: both not
+	// final, or both final with the same labels
+	kind := make([]string, d.NumStates+1)
 	renames := make(map[int]int)
 	for i := 1; i <= d.NumStates; i++ {
 		renames[i] = i
 		if d.IsFinal(i) {
-			is_final[i] = true
+			kind[i] = fmt.Sprint("final", d.Labels[i])
 		}
 	}
 	found_match := true
@@ -192,18 +287,20 @@ func (d *DFA) Minimize() {
 		found_match = false
 		old_graph := d.Graph
 		for i := 1; i <= d.NumStates; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			if to_remove[renames[i]] {
 				continue
 			}
 			for j := 1; j <= d.NumStates; j++ {
-				if to_remove[renames[i]] || renames[i] >= renames[j] {
+				if to_remove[renames[j]] || renames[i] >= renames[j] {
 					continue
 				}
-				if is_final[renames[i]] && !is_final[renames[j]] ||
-					is_final[renames[j]] && !is_final[renames[i]] {
+				if kind[renames[i]] != kind[renames[j]] {
 					continue
 				}
-				match := nodes_match(old_graph, is_final, i, j)
+				match := nodes_match(old_graph, kind, i, j)
 				if match {
 					// join states i and j
 					obsolete := 0
@@ -219,16 +316,11 @@ func (d *DFA) Minimize() {
 						to_remove[obsolete] = true
 						nodes_removed++
 					}
-					for character, nodes := range d.Graph[obsolete] {
+					for character, node := range d.Graph[obsolete] {
 						if _, ok := d.Graph[used]; !ok {
-							d.Graph[used] = make(map[rune][]int)
-						}
-						if _, ok := d.Graph[used][character]; !ok {
-							d.Graph[used][character] = make([]int, 1)
-						} else {
-							transitions_removed++
+							d.Graph[used] = make(map[rune]int)
 						}
-						d.Graph[used][character][0] = renames[nodes[0]]
+						d.Graph[used][character] = renames[node]
 					}
 					renames[obsolete] = renames[used]
 					found_match = true
@@ -241,8 +333,8 @@ func (d *DFA) Minimize() {
 	// TODO: improve the main algorithm so that it doesn't need this
 	for i := 1; i <= d.NumStates; i++ {
 		for character, _ := range d.Graph[i] {
-			for d.Graph[i][character][0] != renames[d.Graph[i][character][0]] {
-				d.Graph[i][character][0] = renames[d.Graph[i][character][0]]
+			for d.Graph[i][character] != renames[d.Graph[i][character]] {
+				d.Graph[i][character] = renames[d.Graph[i][character]]
 			}
 		}
 	}
@@ -267,11 +359,23 @@ func (d *DFA) Minimize() {
 		}
 	}
 	d.FinalStates = final_states
+	labels := make(map[int][]int)
+	for node, node_labels := range d.Labels {
+		if _, ok := mapping[node]; ok {
+			labels[mapping[node]] = node_labels
+		}
+	}
+	d.Labels = labels
 
-	// rename the states found
+	// rename the states found, dropping the transitions to removed states
 	for node, _ := range d.Graph {
 		for character, _ := range d.Graph[node] {
-			d.Graph[node][character][0] = mapping[d.Graph[node][character][0]]
+			next, ok := mapping[d.Graph[node][character]]
+			if !ok {
+				delete(d.Graph[node], character)
+				continue
+			}
+			d.Graph[node][character] = next
 		}
 	}
 	for id := 1; id <= d.NumStates; id++ {
@@ -283,5 +387,43 @@ func (d *DFA) Minimize() {
 		d.Graph[mapping[id]] = d.Graph[id]
 	}
 	d.NumStates -= nodes_removed
-	d.NumTransitions -= transitions_removed
+	d.NumTransitions = 0
+	for node := 1; node <= d.NumStates; node++ {
+		d.NumTransitions += len(d.Graph[node])
+	}
+	return nil
+}
+
+// ToRegex returns a regular expression, in the syntax understood by
+// regex.Parse, that matches the words accepted by the DFA. It works by
+// eliminating the states of the DFA one by one. The transitions on Other
+// are written as '.', or as a negated class of the rest of the alphabet.
+// ok is false if the DFA doesn't accept any word, as there is no
+// expression for that.
+func (d *DFA) ToRegex() (re string, ok bool) {
+	g := gnfa.New(d.NumStates)
+	except := make([]gnfa.Range, 0, len(d.Alphabet))
+	for _, r := range Ranges(d.Alphabet, d.Ends) {
+		except = append(except, gnfa.Range(r))
+	}
+	for node := 1; node <= d.NumStates; node++ {
+		characters := make([]rune, 0, len(d.Graph[node]))
+		for character, _ := range d.Graph[node] {
+			characters = append(characters, character)
+		}
+		sort.Slice(characters, func(i, j int) bool { return characters[i] < characters[j] })
+		for _, character := range characters {
+			next := d.Graph[node][character]
+			if hi, ok := d.Ends[character]; ok {
+				g.AddRange(node, next, character, hi)
+			} else if character != Other {
+				g.AddTransition(node, next, character)
+			} else if hasOther(d.Alphabet) {
+				// without Other in the alphabet, no character takes
+				// the transition
+				g.AddExcept(node, next, except)
+			}
+		}
+	}
+	return g.ToRegex(d.EntryState, d.FinalStates)
 }
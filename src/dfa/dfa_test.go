@@ -1,7 +1,11 @@
 package dfa
 
 import (
+	"context"
+	"math"
+	"math/rand"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -63,6 +67,94 @@ func BenchmarkDFAMinimize(b *testing.B) {
 	}
 }
 
+func BenchmarkDFAMinimizeMoore(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dfa := New()
+		dfa.Process(strings.NewReader(complex_dfa))
+		b.StartTimer()
+		dfa.MinimizeMoore()
+	}
+}
+
+func BenchmarkDFAMinimizeLarge(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dfa := modDFA(120000)
+		b.StartTimer()
+		dfa.Minimize()
+	}
+}
+
+func BenchmarkDFAMinimizeMooreLarge(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dfa := modDFA(300)
+		b.StartTimer()
+		dfa.MinimizeMoore()
+	}
+}
+
+// modDFA returns a DFA with n states reading binary numbers, most
+// significant digit first, that accepts the multiples of 3. n should be a
+// multiple of 3, so that the minimal DFA has 3 states.
+func modDFA(n int) DFA {
+	dfa := New()
+	dfa.NumStates = n
+	dfa.EntryState = 1
+	for i := 0; i < n; i++ {
+		dfa.Graph[i+1] = map[rune]int{
+			'0': (2*i)%n + 1,
+			'1': (2*i+1)%n + 1,
+		}
+		if i%3 == 0 {
+			dfa.FinalStates = append(dfa.FinalStates, i+1)
+		}
+	}
+	dfa.NumTransitions = 2 * n
+	return dfa
+}
+
+// randomDFA returns a DFA over the given alphabet where every transition
+// exists with the given probability
+func randomDFA(r *rand.Rand, states int, alphabet string, density float64) DFA {
+	dfa := New()
+	dfa.NumStates = states
+	dfa.EntryState = 1
+	for node := 1; node <= states; node++ {
+		for _, character := range alphabet {
+			if r.Float64() < density {
+				if _, ok := dfa.Graph[node]; !ok {
+					dfa.Graph[node] = make(map[rune]int)
+				}
+				dfa.Graph[node][character] = 1 + r.Intn(states)
+				dfa.NumTransitions++
+			}
+		}
+		if r.Intn(3) == 0 {
+			dfa.FinalStates = append(dfa.FinalStates, node)
+		}
+	}
+	return dfa
+}
+
+// words returns every word up to the given length over the alphabet
+func words(alphabet string, length int) []string {
+	res := []string{""}
+	last := []string{""}
+	for i := 0; i < length; i++ {
+		next := make([]string, 0, len(last)*len(alphabet))
+		for _, word := range last {
+			for _, character := range alphabet {
+				next = append(next, word+string(character))
+			}
+		}
+		res = append(res, next...)
+		last = next
+	}
+	return res
+}
+
 func TestDFACheck(t *testing.T) {
 	dfa := New()
 	dfa.Process(strings.NewReader(simple_dfa))
@@ -127,3 +219,442 @@ func TestDFAMinimize(t *testing.T) {
 		}
 	}
 }
+
+func TestDFAMinimizeContext(t *testing.T) {
+	dfa := New()
+	dfa.Process(strings.NewReader(complex_dfa))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dfa.MinimizeContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if dfa.NumStates != 8 || dfa.NumTransitions != 16 {
+		t.Fatalf("A cancelled minimization should leave the DFA unchanged")
+	}
+	if err := dfa.MinimizeContext(context.Background()); err != nil {
+		t.Fatalf("MinimizeContext failed: %v", err)
+	}
+	if dfa.NumStates != 5 {
+		t.Errorf("Wrong number of minimized states: %d", dfa.NumStates)
+	}
+}
+
+func TestDFAProcessNondeterministic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Reading two transitions on the same character should fail")
+		}
+	}()
+	dfa := New()
+	dfa.Process(strings.NewReader("3 2\n1 2 a\n1 3 a\n1\n1 3\n"))
+}
+
+func TestDFAMinimizeLabels(t *testing.T) {
+	// a and b lead to final states that would be merged if they had the
+	// same labels
+	labelled := func(first, second []int) DFA {
+		dfa := New()
+		dfa.Process(strings.NewReader("3 2\n1 2 a\n1 3 b\n1\n2 2 3\n"))
+		dfa.Labels[2] = first
+		dfa.Labels[3] = second
+		dfa.Minimize()
+		return dfa
+	}
+	same := labelled([]int{1}, []int{1})
+	if same.NumStates != 2 || len(same.Labels) != 1 || same.Labels[same.Graph[1]['a']][0] != 1 {
+		t.Errorf("Final states with the same labels should be merged: %v", same.Labels)
+	}
+	different := labelled([]int{1}, []int{2})
+	if different.NumStates != 3 {
+		t.Fatalf("Final states with different labels shouldn't be merged")
+	}
+	a := different.Graph[different.EntryState]['a']
+	b := different.Graph[different.EntryState]['b']
+	if len(different.Labels[a]) != 1 || different.Labels[a][0] != 1 ||
+		len(different.Labels[b]) != 1 || different.Labels[b][0] != 2 {
+		t.Errorf("Labels lost by Minimize: %v", different.Labels)
+	}
+}
+
+func TestDFAMinimizeMoore(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 200; i++ {
+		original := randomDFA(r, 1+r.Intn(12), "ab", 0.3+0.7*r.Float64())
+		hopcroft, moore := Copy(original), Copy(original)
+		hopcroft.Minimize()
+		moore.MinimizeMoore()
+		if hopcroft.NumStates != moore.NumStates || hopcroft.NumTransitions != moore.NumTransitions {
+			t.Errorf("Minimize gives %d states and %d transitions, MinimizeMoore %d and %d",
+				hopcroft.NumStates, hopcroft.NumTransitions, moore.NumStates, moore.NumTransitions)
+			original.Print(os.Stderr)
+			continue
+		}
+		for _, word := range words("ab", 7) {
+			if hopcroft.Check(word) != original.Check(word) {
+				t.Errorf("The minimized DFA gives %v for %q", hopcroft.Check(word), word)
+				break
+			}
+		}
+	}
+}
+
+func TestDFAMinimizeLarge(t *testing.T) {
+	dfa := modDFA(30000)
+	dfa.Minimize()
+	if dfa.NumStates != 3 || dfa.NumTransitions != 6 {
+		t.Fatalf("Expected 3 states and 6 transitions, got %d and %d", dfa.NumStates, dfa.NumTransitions)
+	}
+	for _, word := range []string{"", "0", "11", "110", "1001"} {
+		if !dfa.Check(word) {
+			t.Errorf("%q should be accepted", word)
+		}
+	}
+	for _, word := range []string{"1", "10", "111", "1000"} {
+		if dfa.Check(word) {
+			t.Errorf("%q shouldn't be accepted", word)
+		}
+	}
+}
+
+func TestDFAMinimizeBrzozowski(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for i := 0; i < 300; i++ {
+		original := randomDFA(r, 1+r.Intn(15), "abc", 0.2+0.8*r.Float64())
+		hopcroft, moore, brzozowski := Copy(original), Copy(original), Copy(original)
+		hopcroft.Minimize()
+		moore.MinimizeMoore()
+		brzozowski.MinimizeBrzozowski()
+		if !Isomorphic(hopcroft, brzozowski) || !Isomorphic(moore, brzozowski) {
+			t.Errorf("The minimized DFAs aren't isomorphic")
+			original.Print(os.Stderr)
+		}
+	}
+
+	dfa := modDFA(3000)
+	dfa.MinimizeBrzozowski()
+	if dfa.NumStates != 3 {
+		t.Errorf("Expected 3 states, got %d", dfa.NumStates)
+	}
+}
+
+func TestIsomorphic(t *testing.T) {
+	a := New()
+	a.Process(strings.NewReader(simple_dfa))
+	b := New()
+	// simple_dfa with the states numbered backwards
+	b.Process(strings.NewReader("5 4\n5 4 l\n4 3 o\n5 2 a\n2 1 l\n5\n2 3 1"))
+	if !Isomorphic(a, b) || !Isomorphic(b, a) {
+		t.Errorf("Renumbering the states should give an isomorphic DFA")
+	}
+	b.Graph[2]['l'] = 3
+	if Isomorphic(a, b) {
+		t.Errorf("Changing a transition should give a different DFA")
+	}
+	b.Graph[2]['l'] = 1
+	b.FinalStates = []int{3}
+	if Isomorphic(a, b) {
+		t.Errorf("Changing the final states should give a different DFA")
+	}
+	if !Isomorphic(New(), New()) || Isomorphic(a, New()) {
+		t.Errorf("Wrong result for empty DFAs")
+	}
+}
+
+func TestIsMinimal(t *testing.T) {
+	dfa := New()
+	dfa.Process(strings.NewReader(complex_dfa))
+	// 4 can't be reached
+	if minimal, p, q := dfa.IsMinimal(); minimal || p != 4 || q != 0 {
+		t.Errorf("IsMinimal gives %v, %d, %d, expected the unreachable state 4", minimal, p, q)
+	}
+	dfa.Minimize()
+	if minimal, p, q := dfa.IsMinimal(); !minimal {
+		t.Errorf("The minimized DFA isn't minimal: %d and %d", p, q)
+	}
+
+	// 4 is unreachable, and 3 can't lead to a final state
+	dfa = New()
+	dfa.Process(strings.NewReader("4 3\n1 2 a\n2 3 b\n4 2 a\n1\n1 2\n"))
+	if minimal, p, q := dfa.IsMinimal(); minimal || p != 3 || q != 0 {
+		t.Errorf("IsMinimal gives %v, %d, %d, expected the useless state 3", minimal, p, q)
+	}
+	dfa.Graph[2] = map[rune]int{}
+	if minimal, p, q := dfa.IsMinimal(); minimal || p != 3 || q != 0 {
+		t.Errorf("IsMinimal gives %v, %d, %d, expected the useless state 3", minimal, p, q)
+	}
+
+	// a and b lead to equivalent states
+	dfa = New()
+	dfa.Process(strings.NewReader("3 4\n1 2 a\n1 3 b\n2 2 a\n3 3 a\n1\n2 2 3\n"))
+	if minimal, p, q := dfa.IsMinimal(); minimal || p != 2 || q != 3 {
+		t.Errorf("IsMinimal gives %v, %d, %d, expected the equivalent states 2 and 3", minimal, p, q)
+	}
+
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		dfa := randomDFA(r, 1+r.Intn(10), "ab", 0.3+0.7*r.Float64())
+		minimal, p, q := dfa.IsMinimal()
+		minimized := Copy(dfa)
+		minimized.Minimize()
+		if minimal != (minimized.NumStates == dfa.NumStates) {
+			t.Errorf("IsMinimal gives %v for a DFA of %d states, %d when minimized", minimal, dfa.NumStates, minimized.NumStates)
+		}
+		if minimal, _, _ := minimized.IsMinimal(); !minimal {
+			t.Errorf("The minimized DFA isn't minimal")
+		}
+		if q > 0 {
+			// both states should accept the same words
+			for _, word := range words("ab", 6) {
+				from_p, from_q := Copy(dfa), Copy(dfa)
+				from_p.EntryState, from_q.EntryState = p, q
+				if from_p.Check(word) != from_q.Check(word) {
+					t.Errorf("States %d and %d differ on %q", p, q, word)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// a followed by any character but b
+	dfa := New()
+	dfa.Process(strings.NewReader("3 2\n1 2 a\n2 3 a\n1\n1 3\n"))
+	dfa.Graph[2][Other] = 3
+	dfa.NumTransitions++
+	if dfa.Check("az") {
+		t.Errorf("Other shouldn't be taken into account with an implicit alphabet")
+	}
+	dfa.Alphabet = []rune{Other, 'a', 'b'}
+	tests := map[string]bool{"aa": true, "az": true, "aλ": true, "ab": false, "a": false, "za": false}
+	for word, res := range tests {
+		if dfa.Check(word) != res {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+	if !dfa.InAlphabet('b') || dfa.InAlphabet('z') {
+		t.Errorf("Wrong alphabet: %q", dfa.Symbols())
+	}
+	dfa.Minimize()
+	if len(dfa.Alphabet) != 3 {
+		t.Errorf("Minimize lost the alphabet: %q", dfa.Alphabet)
+	}
+	for word, res := range tests {
+		if dfa.Check(word) != res {
+			t.Errorf("Wrong answer of the minimized DFA at: %q", word)
+		}
+	}
+}
+
+func TestRefine(t *testing.T) {
+	alphabet, ends := Refine([]Range{{'a', 'z'}, {'m', 'm'}, {'x', '|'}, {'z' + 10, 'z' + 5}}, false)
+	if string(alphabet) != "amnx{" {
+		t.Errorf("Wrong alphabet: %q", alphabet)
+	}
+	expected := map[rune]rune{'a': 'l', 'n': 'w', 'x': 'z', '{': '|'}
+	if !reflect.DeepEqual(ends, expected) {
+		t.Errorf("Wrong ends: %q", ends)
+	}
+	alphabet, ends = Refine([]Range{{0, math.MaxInt32}, {'a', 'a'}}, true)
+	if !reflect.DeepEqual(alphabet, []rune{Other, 0, 'a', 'b'}) || ends['b'] != math.MaxInt32 {
+		t.Errorf("Wrong alphabet: %q, %q", alphabet, ends)
+	}
+
+	// [a-l]m[n-z]* over ranges, and [a-z]*x with a range holding x
+	lmn := New()
+	lmn.Process(strings.NewReader("3 3\n1 2 a\n2 3 m\n3 3 n\n1\n1 3\n"))
+	lmn.Alphabet, lmn.Ends = []rune{Other, 'a', 'm', 'n'}, map[rune]rune{'a': 'l', 'n': 'z'}
+	x := New()
+	x.Process(strings.NewReader("2 4\n1 1 a\n1 2 x\n2 1 a\n2 2 x\n1\n1 2\n"))
+	x.Alphabet, x.Ends = []rune{'a', 'x', 'y'}, map[rune]rune{'a': 'w', 'y': 'z'}
+	x.Graph[1]['y'], x.Graph[2]['y'] = 1, 1
+	x.NumTransitions += 2
+	tests := map[string][2]bool{
+		"km":   [2]bool{true, false},
+		"kmx":  [2]bool{true, true},
+		"kmxy": [2]bool{true, false},
+		"mm":   [2]bool{false, false},
+		"yx":   [2]bool{false, true},
+		"Ax":   [2]bool{false, false},
+	}
+	for word, expected := range tests {
+		if lmn.Check(word) != expected[0] || x.Check(word) != expected[1] {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+	if !lmn.InAlphabet('q') || lmn.InAlphabet('A') || x.InAlphabet('A') {
+		t.Errorf("Wrong alphabet: %q", lmn.Symbols())
+	}
+	both, either := Intersect(lmn, x, true), Union(lmn, x, true)
+	if string(both.Alphabet) != string([]rune{Other, 'a', 'm', 'n', 'x', 'y'}) {
+		t.Errorf("Wrong alphabet of the product: %q", both.Alphabet)
+	}
+	for word, expected := range tests {
+		if both.Check(word) != (expected[0] && expected[1]) || either.Check(word) != (expected[0] || expected[1]) {
+			t.Errorf("Wrong answer of the product at: %q", word)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	complete := New()
+	complete.Process(strings.NewReader(complex_dfa))
+	if !complete.IsComplete() {
+		t.Errorf("The DFA should be complete")
+	}
+	dfa := New()
+	dfa.Process(strings.NewReader("3 4\n1 2 a\n1 3 b\n2 2 a\n3 3 a\n1\n2 2 3\n"))
+	original := Copy(dfa)
+	if dfa.IsComplete() {
+		t.Fatalf("The DFA shouldn't be complete")
+	}
+	dfa.Complete()
+	if !dfa.IsComplete() || dfa.NumStates != original.NumStates+1 {
+		t.Fatalf("Complete gives %d states, complete: %v", dfa.NumStates, dfa.IsComplete())
+	}
+	alphabet := string(dfa.Alphabet)
+	if alphabet != string(original.Symbols()) {
+		t.Errorf("Wrong alphabet: %q", alphabet)
+	}
+	if dfa.NumTransitions != dfa.NumStates*len(dfa.Alphabet) {
+		t.Errorf("Wrong number of transitions: %d", dfa.NumTransitions)
+	}
+	for _, word := range words(alphabet, 6) {
+		if dfa.Check(word) != original.Check(word) {
+			t.Errorf("The completed DFA gives %v for %q", dfa.Check(word), word)
+			break
+		}
+	}
+	dfa.Minimize()
+	original.Minimize()
+	original.Alphabet = dfa.Alphabet
+	if !Isomorphic(dfa, original) {
+		t.Errorf("Minimize should remove the sink state")
+	}
+
+	empty := New()
+	empty.Alphabet = []rune{Other}
+	empty.Complete()
+	if empty.NumStates != 1 || empty.EntryState != 1 || empty.Graph[1][Other] != 1 || empty.Check("abc") {
+		t.Errorf("Completing an empty DFA should give a single sink state")
+	}
+}
+
+func TestComplement(t *testing.T) {
+	dfa := New()
+	dfa.Process(strings.NewReader("3 4\n1 2 a\n1 3 b\n2 2 a\n3 3 a\n1\n2 2 3\n"))
+	dfa.Labels[2] = []int{0}
+	original := Copy(dfa)
+	complement := dfa.Complement()
+	if !Isomorphic(dfa, original) {
+		t.Errorf("Complement changed the DFA")
+	}
+	if !complement.IsComplete() || len(complement.Labels) != 0 {
+		t.Errorf("The complement should be complete and without labels")
+	}
+	for _, word := range words("ab", 6) {
+		if complement.Check(word) == dfa.Check(word) {
+			t.Errorf("The complement gives %v for %q", complement.Check(word), word)
+			break
+		}
+	}
+	if complement.Check("ac") {
+		t.Errorf("The complement shouldn't accept characters outside the alphabet")
+	}
+
+	twice := complement.Complement()
+	twice.Minimize()
+	original.Alphabet, original.Labels = twice.Alphabet, twice.Labels
+	original.Minimize()
+	if !Isomorphic(twice, original) {
+		t.Errorf("The complement of the complement should be the DFA")
+	}
+
+	// with Other, the complement accepts any character
+	dfa.Alphabet = []rune{Other, 'a', 'b'}
+	complement = dfa.Complement()
+	tests := map[string]bool{"": true, "a": false, "ab": true, "ac": true, "baa": false, "λ": true}
+	for word, res := range tests {
+		if complement.Check(word) != res {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+
+	empty := New()
+	if complement := empty.Complement(); !complement.Check("") || complement.Check("a") {
+		t.Errorf("The complement of an empty DFA over no characters only accepts the empty word")
+	}
+}
+
+// withOther gives random transitions on Other to the DFA, over the given
+// characters
+func withOther(r *rand.Rand, dfa DFA, alphabet string) DFA {
+	dfa.Alphabet = append([]rune{Other}, []rune(alphabet)...)
+	for node := 1; node <= dfa.NumStates; node++ {
+		if r.Intn(2) == 0 {
+			if _, ok := dfa.Graph[node]; !ok {
+				dfa.Graph[node] = make(map[rune]int)
+			}
+			dfa.Graph[node][Other] = 1 + r.Intn(dfa.NumStates)
+			dfa.NumTransitions++
+		}
+	}
+	return dfa
+}
+
+func TestProduct(t *testing.T) {
+	type Operation struct {
+		Name    string
+		Build   func(a, b DFA, minimize bool) DFA
+		Expects func(x, y bool) bool
+	}
+	operations := []Operation{
+		Operation{"Intersect", Intersect, func(x, y bool) bool { return x && y }},
+		Operation{"Union", Union, func(x, y bool) bool { return x || y }},
+		Operation{"Difference", Difference, func(x, y bool) bool { return x && !y }},
+		Operation{"SymmetricDifference", SymmetricDifference, func(x, y bool) bool { return x != y }},
+	}
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 100; i++ {
+		a := randomDFA(r, 1+r.Intn(6), "ab", 0.3+0.7*r.Float64())
+		b := randomDFA(r, 1+r.Intn(6), "bc", 0.3+0.7*r.Float64())
+		if i%2 == 1 {
+			a = withOther(r, a, "ab")
+		}
+		if i%4 >= 2 {
+			b = withOther(r, b, "bc")
+		}
+		for _, op := range operations {
+			res, minimized := op.Build(a, b, false), op.Build(a, b, true)
+			if minimal, p, q := minimized.IsMinimal(); !minimal {
+				t.Errorf("%s: the result isn't minimal, at %d and %d", op.Name, p, q)
+			}
+			for _, word := range words("abcz", 5) {
+				expected := op.Expects(a.Check(word), b.Check(word))
+				if res.Check(word) != expected || minimized.Check(word) != expected {
+					t.Errorf("%s: wrong answer at %q for test %d", op.Name, word, i)
+					a.Print(os.Stderr)
+					b.Print(os.Stderr)
+					break
+				}
+			}
+		}
+	}
+
+	// a DFA without any words is the neutral element of Union, and a's
+	// difference with itself is empty
+	a := New()
+	a.Process(strings.NewReader(complex_dfa))
+	union := Union(a, New(), true)
+	minimized := Copy(a)
+	minimized.Minimize()
+	if !Isomorphic(union, minimized) {
+		t.Errorf("The union with an empty DFA should be the DFA")
+	}
+	if difference := Difference(a, a, true); difference.NumStates != 0 {
+		t.Errorf("The difference of a DFA with itself should be empty, got %d states", difference.NumStates)
+	}
+	if intersection := Intersect(a, New(), false); intersection.NumStates != 0 {
+		t.Errorf("The intersection with an empty DFA should be empty, got %d states", intersection.NumStates)
+	}
+}
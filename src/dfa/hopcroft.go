@@ -0,0 +1,382 @@
+package dfa
+
+import (
+	"context"
+	"fmt"
+	"queue"
+	"sort"
+)
+
+// hopcroft minimizes the DFA with Hopcroft's partition refinement
+// algorithm (see Hopcroft, "An n log n algorithm for minimizing states in a
+// finite automaton"). Missing transitions lead to an implicit sink state,
+// which is dropped again at the end along with the states that can't be
+// reached or can't lead to a final state.
+func (d *DFA) hopcroft(ctx context.Context) error {
+	c, err := d.equivalenceClasses(ctx)
+	if err != nil {
+		return err
+	}
+	if c.indexOf(d.EntryState) < 0 {
+		alphabet, ends := d.Alphabet, d.Ends
+		*d = New()
+		d.Alphabet, d.Ends = alphabet, ends
+		return nil
+	}
+	p, n, sink := c.p, len(c.states), len(c.states)
+
+	// number the blocks in the order of their smallest state, leaving out
+	// the sink's, and rebuild the DFA from one state of each block
+	first := make([]int, p.numBlocks())
+	for b := range first {
+		first[b] = n + 1
+	}
+	for i := 0; i <= n; i++ {
+		if b := p.block[i]; i < first[b] {
+			first[b] = i
+		}
+	}
+	order := make([]int, 0, p.numBlocks())
+	for b := range first {
+		if b != p.block[sink] {
+			order = append(order, b)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool { return first[order[i]] < first[order[j]] })
+	mapping := make([]int, p.numBlocks())
+	for id, b := range order {
+		mapping[b] = id + 1
+	}
+
+	res := New()
+	res.Alphabet, res.Ends = d.Alphabet, d.Ends
+	res.NumStates = len(order)
+	res.EntryState = mapping[p.block[c.indexOf(d.EntryState)]]
+	for id, b := range order {
+		node := c.states[first[b]]
+		for character, neighbour := range d.Graph[node] {
+			j := c.indexOf(neighbour)
+			if j < 0 {
+				continue
+			}
+			if _, ok := res.Graph[id+1]; !ok {
+				res.Graph[id+1] = make(map[rune]int)
+			}
+			res.Graph[id+1][character] = mapping[p.block[j]]
+			res.NumTransitions++
+		}
+		if c.is_final[first[b]] {
+			res.FinalStates = append(res.FinalStates, id+1)
+			if labels, ok := d.Labels[node]; ok {
+				res.Labels[id+1] = labels
+			}
+		}
+	}
+	*d = res
+	return nil
+}
+
+// classes splits the useful states of a DFA into blocks of equivalent
+// states. They are numbered from 0 in increasing order, and the last number
+// stands for an implicit sink state that missing transitions lead to.
+type classes struct {
+	states []int
+	// the number of every state of the DFA, or -1 if it isn't useful
+	index    []int
+	is_final []bool
+	p        *partition
+}
+
+// indexOf returns the number of a useful state, or -1
+func (c *classes) indexOf(node int) int {
+	if node < 0 || node >= len(c.index) {
+		return -1
+	}
+	return c.index[node]
+}
+
+// equivalenceClasses splits the useful states of the DFA into blocks of
+// equivalent states, by Hopcroft's algorithm
+func (d *DFA) equivalenceClasses(ctx context.Context) (*classes, error) {
+	// keep the useful states, numbered from 0 in increasing order, then
+	// add the sink
+	useful := d.usefulStates()
+	index := make([]int, d.NumStates+1)
+	states := make([]int, 0, d.NumStates)
+	for node := 0; node <= d.NumStates; node++ {
+		index[node] = -1
+		if useful[node] {
+			index[node] = len(states)
+			states = append(states, node)
+		}
+	}
+	res := &classes{states: states, index: index}
+	indexOf := res.indexOf
+	n := len(states)
+	sink := n
+
+	is_final := make([]bool, n+1)
+	res.is_final = is_final
+	for _, node := range d.FinalStates {
+		if i := indexOf(node); i >= 0 {
+			is_final[i] = true
+		}
+	}
+	seen := make(map[rune]bool)
+	alphabet := make([]rune, 0)
+	for _, node := range states {
+		for character, _ := range d.Graph[node] {
+			if !seen[character] {
+				seen[character] = true
+				alphabet = append(alphabet, character)
+			}
+		}
+	}
+	sort.Slice(alphabet, func(i, j int) bool { return alphabet[i] < alphabet[j] })
+	inverse := make([]inverseGraph, len(alphabet))
+	next := make([]int, n+1)
+	for c, character := range alphabet {
+		for i := 0; i <= n; i++ {
+			next[i] = sink
+			if i == sink {
+				continue
+			}
+			if neighbour, ok := d.Graph[states[i]][character]; ok && indexOf(neighbour) >= 0 {
+				next[i] = indexOf(neighbour)
+			}
+		}
+		inverse[c] = newInverseGraph(next)
+	}
+
+	// the initial partition puts together the states of the same kind:
+	// the non-final ones, and the final ones with the same labels
+	p := newPartition(n + 1)
+	kinds := make(map[string]int)
+	kind := make([]int, n+1)
+	for i := 0; i <= n; i++ {
+		key := ""
+		if is_final[i] {
+			key = fmt.Sprint("final", d.Labels[states[i]])
+		}
+		if _, ok := kinds[key]; !ok {
+			kinds[key] = len(kinds)
+		}
+		kind[i] = kinds[key]
+	}
+	p.init(kind, len(kinds))
+
+	// every block but the largest one starts as a splitter
+	worklist := queue.New(p.numBlocks())
+	in_worklist := make([]bool, p.numBlocks())
+	largest := 0
+	for b := 0; b < p.numBlocks(); b++ {
+		if p.size(b) > p.size(largest) {
+			largest = b
+		}
+	}
+	for b := 0; b < p.numBlocks(); b++ {
+		if b != largest {
+			worklist.Push(b)
+			in_worklist[b] = true
+		}
+	}
+	splitter := make([]int, 0)
+	marked := make([]int, 0)
+	for !worklist.Empty() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, _ := worklist.Pop()
+		in_worklist[b] = false
+		splitter = append(splitter[:0], p.elements(b)...)
+		for c := range alphabet {
+			marked = marked[:0]
+			for _, q := range splitter {
+				marked = append(marked, inverse[c].sources(q)...)
+			}
+			for _, split := range p.split(marked) {
+				for len(in_worklist) < p.numBlocks() {
+					in_worklist = append(in_worklist, false)
+				}
+				// split[0] lost the states now in split[1]; if it's still
+				// to be used as a splitter, so is the new block, otherwise
+				// the smaller one is enough
+				if in_worklist[split[0]] || p.size(split[1]) <= p.size(split[0]) {
+					worklist.Push(split[1])
+					in_worklist[split[1]] = true
+				} else {
+					worklist.Push(split[0])
+					in_worklist[split[0]] = true
+				}
+			}
+		}
+	}
+
+	res.p = p
+	return res, nil
+}
+
+// usefulStates returns the states that can be reached from the entry state
+// and can lead to a final state
+func (d *DFA) usefulStates() []bool {
+	reachable := make([]bool, d.NumStates+1)
+	reverse := make(map[int][]int)
+	q := queue.New(d.NumStates + 1)
+	if d.EntryState > 0 {
+		reachable[d.EntryState] = true
+		q.Push(d.EntryState)
+	}
+	for !q.Empty() {
+		node, _ := q.Pop()
+		for _, neighbour := range d.Graph[node] {
+			if neighbour < 1 || neighbour > d.NumStates {
+				continue
+			}
+			reverse[neighbour] = append(reverse[neighbour], node)
+			if !reachable[neighbour] {
+				reachable[neighbour] = true
+				q.Push(neighbour)
+			}
+		}
+	}
+	res := make([]bool, d.NumStates+1)
+	for _, node := range d.FinalStates {
+		if reachable[node] && !res[node] {
+			res[node] = true
+			q.Push(node)
+		}
+	}
+	for !q.Empty() {
+		node, _ := q.Pop()
+		for _, neighbour := range reverse[node] {
+			if !res[neighbour] {
+				res[neighbour] = true
+				q.Push(neighbour)
+			}
+		}
+	}
+	return res
+}
+
+// inverseGraph lists, for every state, the states going to it by reading
+// a given character
+type inverseGraph struct {
+	start, from []int
+}
+
+// newInverseGraph reverses the transitions on a character, given as the
+// state next[i] reached from every state i
+func newInverseGraph(next []int) inverseGraph {
+	res := inverseGraph{make([]int, len(next)+1), make([]int, len(next))}
+	for _, q := range next {
+		res.start[q+1]++
+	}
+	for q := 1; q <= len(next); q++ {
+		res.start[q] += res.start[q-1]
+	}
+	fill := make([]int, len(next))
+	copy(fill, res.start)
+	for i, q := range next {
+		res.from[fill[q]] = i
+		fill[q]++
+	}
+	return res
+}
+
+func (g inverseGraph) sources(q int) []int {
+	return g.from[g.start[q]:g.start[q+1]]
+}
+
+// partition splits the states 0..n-1 into blocks. The states of a block are
+// kept next to each other in elems, so that a block can be split in time
+// proportional to the number of states taken out of it.
+type partition struct {
+	elems []int
+	// position of every state in elems
+	pos []int
+	// block of every state
+	block []int
+	// the states of block b are elems[start[b]:end[b]]
+	start, end []int
+	// number of states marked in every block, which are moved to its front
+	marked []int
+}
+
+func newPartition(n int) *partition {
+	return &partition{
+		elems: make([]int, n),
+		pos:   make([]int, n),
+		block: make([]int, n),
+	}
+}
+
+// init puts the states with equal kinds, numbered from 0 to count-1, in the
+// same blocks
+func (p *partition) init(kind []int, count int) {
+	sizes := make([]int, count)
+	for _, k := range kind {
+		sizes[k]++
+	}
+	p.start = make([]int, count)
+	p.end = make([]int, count)
+	p.marked = make([]int, count)
+	for k := 1; k < count; k++ {
+		p.start[k] = p.start[k-1] + sizes[k-1]
+	}
+	copy(p.end, p.start)
+	for state, k := range kind {
+		p.elems[p.end[k]] = state
+		p.pos[state] = p.end[k]
+		p.block[state] = k
+		p.end[k]++
+	}
+}
+
+func (p *partition) numBlocks() int {
+	return len(p.start)
+}
+
+func (p *partition) size(b int) int {
+	return p.end[b] - p.start[b]
+}
+
+func (p *partition) elements(b int) []int {
+	return p.elems[p.start[b]:p.end[b]]
+}
+
+// split takes the given states, which must be distinct, out of every block
+// that also has other states, into new blocks. It returns the pairs of the
+// old and new blocks.
+func (p *partition) split(states []int) [][2]int {
+	touched := make([]int, 0)
+	for _, state := range states {
+		b := p.block[state]
+		if p.marked[b] == 0 {
+			touched = append(touched, b)
+		}
+		// swap the state with the first unmarked one of its block
+		i, j := p.pos[state], p.start[b]+p.marked[b]
+		other := p.elems[j]
+		p.elems[i], p.elems[j] = other, state
+		p.pos[other], p.pos[state] = i, j
+		p.marked[b]++
+	}
+	res := make([][2]int, 0)
+	for _, b := range touched {
+		marked := p.marked[b]
+		p.marked[b] = 0
+		if marked == p.size(b) {
+			continue
+		}
+		nb := len(p.start)
+		p.start = append(p.start, p.start[b])
+		p.end = append(p.end, p.start[b]+marked)
+		p.marked = append(p.marked, 0)
+		p.start[b] += marked
+		for _, state := range p.elements(nb) {
+			p.block[state] = nb
+		}
+		res = append(res, [2]int{b, nb})
+	}
+	return res
+}
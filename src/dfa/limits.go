@@ -0,0 +1,28 @@
+package dfa
+
+import "errors"
+
+var (
+	// ErrTooManyStates is returned when building an automaton would need
+	// more states than allowed by the Limits
+	ErrTooManyStates = errors.New("Too many states")
+
+	// ErrTooMuchMemory is returned when building an automaton would need
+	// more memory than allowed by the Limits
+	ErrTooMuchMemory = errors.New("Too much memory")
+)
+
+// Limits bounds the resources used while compiling a regular expression,
+// so that untrusted patterns can't keep the CPU and memory busy for long.
+// Zero fields mean no limit.
+type Limits struct {
+	// Maximum number of states of the NFA built from the regular expression
+	MaxNFAStates int
+
+	// Maximum number of states of the DFA built from the NFA
+	MaxDFAStates int
+
+	// Maximum number of bytes, roughly estimated, used while building the
+	// DFA from the NFA
+	MaxMemory int
+}
@@ -0,0 +1,30 @@
+package dfa
+
+import (
+	"context"
+)
+
+// IsMinimal checks whether the DFA is minimal: every state can be reached
+// from the entry state and can lead to a final state, and no two states
+// accept the same words. If it isn't, it returns the states at fault,
+// either a single state that is unreachable or useless as p, with q = 0, or
+// two equivalent states p < q.
+func (d *DFA) IsMinimal() (minimal bool, p, q int) {
+	c, _ := d.equivalenceClasses(context.Background())
+	for node := 1; node <= d.NumStates; node++ {
+		if c.indexOf(node) < 0 {
+			return false, node, 0
+		}
+	}
+	// the states are numbered in increasing order, so the first state seen
+	// in every block is its smallest one
+	first := make(map[int]int)
+	for i, node := range c.states {
+		b := c.p.block[i]
+		if other, ok := first[b]; ok {
+			return false, other, node
+		}
+		first[b] = node
+	}
+	return true, 0, 0
+}
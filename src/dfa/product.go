@@ -0,0 +1,117 @@
+package dfa
+
+import (
+	"queue"
+	"sort"
+)
+
+// Intersect returns a DFA accepting the words accepted by both DFAs. If
+// minimize is true, the result is minimized before being returned.
+func Intersect(a, b DFA, minimize bool) DFA {
+	return product(a, b, func(x, y bool) bool { return x && y }, minimize)
+}
+
+// Union returns a DFA accepting the words accepted by any of the DFAs. If
+// minimize is true, the result is minimized before being returned.
+func Union(a, b DFA, minimize bool) DFA {
+	return product(a, b, func(x, y bool) bool { return x || y }, minimize)
+}
+
+// Difference returns a DFA accepting the words accepted by a but not by b.
+// If minimize is true, the result is minimized before being returned.
+func Difference(a, b DFA, minimize bool) DFA {
+	return product(a, b, func(x, y bool) bool { return x && !y }, minimize)
+}
+
+// SymmetricDifference returns a DFA accepting the words accepted by exactly
+// one of the DFAs. If minimize is true, the result is minimized before
+// being returned.
+func SymmetricDifference(a, b DFA, minimize bool) DFA {
+	return product(a, b, func(x, y bool) bool { return x != y }, minimize)
+}
+
+// product runs both DFAs side by side: its states are the pairs of states
+// reached by the same word, and a pair is final if accept gives true for
+// whether its states are final. accept(false, false) must be false.
+//
+// A missing transition leads to the state 0 of its DFA, which rejects
+// everything, so that pairs keep going as long as one of the DFAs does,
+// unless accept tells that the other DFA can't make them final on its own.
+// Only the pairs that can be reached become states. The alphabet is the
+// union of both alphabets, with ranges split where they overlap, and a
+// character that is only in one of them is read through Other in the other
+// DFA, if it has Other. Labels are dropped.
+func product(a, b DFA, accept func(x, y bool) bool, minimize bool) DFA {
+	res := New()
+	alphabet, ends := unionSymbols(a, b)
+	if a.Alphabet != nil || b.Alphabet != nil {
+		res.Alphabet, res.Ends = alphabet, ends
+	}
+	is_final_a := make([]bool, a.NumStates+1)
+	for _, node := range a.FinalStates {
+		is_final_a[node] = true
+	}
+	is_final_b := make([]bool, b.NumStates+1)
+	for _, node := range b.FinalStates {
+		is_final_b[node] = true
+	}
+	// a pair with a state 0 only accepts words if the other DFA alone can
+	// make it final
+	dead := func(p, q int) bool {
+		return (p == 0 && (q == 0 || !accept(false, true))) ||
+			(q == 0 && !accept(true, false))
+	}
+
+	pairs := make([][2]int, 1)
+	ids := make(map[[2]int]int)
+	q := queue.New(a.NumStates + b.NumStates + 1)
+	add := func(pair [2]int) int {
+		if id, ok := ids[pair]; ok {
+			return id
+		}
+		pairs = append(pairs, pair)
+		id := len(pairs) - 1
+		ids[pair] = id
+		q.Push(id)
+		return id
+	}
+	if dead(a.EntryState, b.EntryState) {
+		return res
+	}
+	res.EntryState = add([2]int{a.EntryState, b.EntryState})
+	for !q.Empty() {
+		id, _ := q.Pop()
+		p, r := pairs[id][0], pairs[id][1]
+		if accept(is_final_a[p], is_final_b[r]) {
+			res.FinalStates = append(res.FinalStates, id)
+		}
+		for _, character := range alphabet {
+			next_a, _ := a.Next(p, character)
+			next_b, _ := b.Next(r, character)
+			if dead(next_a, next_b) {
+				continue
+			}
+			next := add([2]int{next_a, next_b})
+			if _, ok := res.Graph[id]; !ok {
+				res.Graph[id] = make(map[rune]int)
+			}
+			res.Graph[id][character] = next
+			res.NumTransitions++
+		}
+	}
+	res.NumStates = len(pairs) - 1
+	sort.Ints(res.FinalStates)
+	if minimize {
+		res.Minimize()
+	}
+	return res
+}
+
+// unionSymbols returns the alphabet made of the characters of the
+// alphabets of both DFAs, split so that each of its symbols stands for
+// whole symbols of both
+func unionSymbols(a, b DFA) ([]rune, map[rune]rune) {
+	ranges := append(Ranges(a.Symbols(), a.Ends), Ranges(b.Symbols(), b.Ends)...)
+	other := hasOther(a.Symbols()) || hasOther(b.Symbols())
+	return Refine(ranges, other)
+}
@@ -0,0 +1,514 @@
+package gnfa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// characters that have to be escaped with a backslash to stand for
+// themselves; these must match the ones understood by regex.Parse, as must
+// the \x{...} codes of those that can't be printed
+const metacharacters = `()|*\.[]{}`
+
+// characters that have to be escaped inside a class
+const classMetacharacters = `\]-^`
+
+// Range is an interval of characters, both ends included
+type Range struct {
+	Lo, Hi rune
+}
+
+// GNFA is a generalized NFA, whose transitions are labelled with regular
+// expressions instead of single characters. It is used to turn automata
+// back into regular expressions, by eliminating their states one by one
+// (see Sipser, "Introduction to the Theory of Computation", 1.3).
+type GNFA struct {
+	numStates int
+	// edges[from][to] is the expression labelling the transition, if any
+	edges map[int]map[int]*expr
+	// in[to][from] is set if there is a transition from -> to
+	in map[int]map[int]bool
+}
+
+// New returns a GNFA with the states 0 to numStates and no transitions.
+func New(numStates int) *GNFA {
+	return &GNFA{
+		numStates: numStates,
+		edges:     make(map[int]map[int]*expr),
+		in:        make(map[int]map[int]bool),
+	}
+}
+
+// AddTransition adds a transition that reads the given character
+func (g *GNFA) AddTransition(from, to int, char rune) {
+	g.add(from, to, &expr{op: opLiteral, char: char})
+}
+
+// AddRange adds a transition that reads any character from lo to hi
+func (g *GNFA) AddRange(from, to int, lo, hi rune) {
+	g.add(from, to, characters([]Range{{lo, hi}}))
+}
+
+// AddExcept adds a transition that reads any valid character outside of
+// the given ranges. Nothing is added if the ranges hold every valid
+// character.
+func (g *GNFA) AddExcept(from, to int, ranges []Range) {
+	g.add(from, to, characters(complement(merge(ranges))))
+}
+
+// AddEpsilon adds a transition that doesn't read anything
+func (g *GNFA) AddEpsilon(from, to int) {
+	g.add(from, to, epsilon)
+}
+
+// add ORs the expression with the one already on the transition
+func (g *GNFA) add(from, to int, e *expr) {
+	if e == nil {
+		return
+	}
+	if _, ok := g.edges[from]; !ok {
+		g.edges[from] = make(map[int]*expr)
+	}
+	if _, ok := g.in[to]; !ok {
+		g.in[to] = make(map[int]bool)
+	}
+	g.edges[from][to] = alternate(g.edges[from][to], e)
+	g.in[to][from] = true
+}
+
+func (g *GNFA) remove(state int) {
+	for to, _ := range g.edges[state] {
+		delete(g.in[to], state)
+	}
+	for from, _ := range g.in[state] {
+		delete(g.edges[from], state)
+	}
+	delete(g.edges, state)
+	delete(g.in, state)
+}
+
+// ToRegex returns a regular expression matching the words that lead from
+// the entry state to one of the final states. As there is no way to write
+// the empty language in the syntax of regex.Parse, ok is false if there is
+// no such word.
+//
+// States are eliminated starting with the ones that have the fewest
+// transitions going in and out of them, which keeps the expression small.
+func (g *GNFA) ToRegex(entry int, finals []int) (re string, ok bool) {
+	start, end := g.numStates+1, g.numStates+2
+	g.AddEpsilon(start, entry)
+	for _, state := range finals {
+		g.AddEpsilon(state, end)
+	}
+	useful := g.useful(start, end)
+	for state := 0; state <= g.numStates; state++ {
+		if !useful[state] {
+			g.remove(state)
+		}
+	}
+
+	for {
+		best, best_cost := -1, 0
+		for state := 0; state <= g.numStates; state++ {
+			if !useful[state] {
+				continue
+			}
+			ins, outs := len(g.in[state]), len(g.edges[state])
+			if _, ok := g.edges[state][state]; ok {
+				ins--
+				outs--
+			}
+			if cost := ins * outs; best == -1 || cost < best_cost {
+				best, best_cost = state, cost
+			}
+		}
+		if best == -1 {
+			break
+		}
+		g.eliminate(best)
+		useful[best] = false
+	}
+
+	if g.edges[start][end] == nil {
+		return "", false
+	}
+	return g.edges[start][end].String(), true
+}
+
+// eliminate removes a state, replacing every path p -> state -> q with a
+// transition p -> q
+func (g *GNFA) eliminate(state int) {
+	loop := star(g.edges[state][state])
+	// go through the states in order, so that the result doesn't depend on
+	// the order of map iteration
+	froms := make([]int, 0, len(g.in[state]))
+	for from, _ := range g.in[state] {
+		if from != state {
+			froms = append(froms, from)
+		}
+	}
+	tos := make([]int, 0, len(g.edges[state]))
+	for to, _ := range g.edges[state] {
+		if to != state {
+			tos = append(tos, to)
+		}
+	}
+	sort.Ints(froms)
+	sort.Ints(tos)
+	for _, from := range froms {
+		for _, to := range tos {
+			g.add(from, to, concat(g.edges[from][state], concat(loop, g.edges[state][to])))
+		}
+	}
+	g.remove(state)
+}
+
+// useful returns the states that are on a path from start to end
+func (g *GNFA) useful(start, end int) []bool {
+	size := g.numStates + 3
+	reachable := make([]bool, size)
+	coreachable := make([]bool, size)
+	stack := []int{start}
+	reachable[start] = true
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for to, _ := range g.edges[state] {
+			if !reachable[to] {
+				reachable[to] = true
+				stack = append(stack, to)
+			}
+		}
+	}
+	stack = []int{end}
+	coreachable[end] = true
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for from, _ := range g.in[state] {
+			if !coreachable[from] {
+				coreachable[from] = true
+				stack = append(stack, from)
+			}
+		}
+	}
+	res := make([]bool, size)
+	for state := 0; state < size; state++ {
+		res[state] = reachable[state] && coreachable[state]
+	}
+	return res
+}
+
+type op int
+
+const (
+	opEpsilon op = iota
+	opLiteral
+	opConcat
+	opAlternate
+	opStar
+	// a set of characters, written as a class, a negated class or '.'
+	opClass
+)
+
+// expr is a regular expression labelling a transition; nil stands for the
+// empty language
+type expr struct {
+	op   op
+	char rune
+	// the ranges of characters of a class, sorted and merged
+	ranges []Range
+	sub    []*expr
+	// the expression as a string, filled in on demand
+	text string
+}
+
+var epsilon = &expr{op: opEpsilon}
+
+func (e *expr) nullable() bool {
+	switch e.op {
+	case opEpsilon, opStar:
+		return true
+	case opConcat:
+		for _, sub := range e.sub {
+			if !sub.nullable() {
+				return false
+			}
+		}
+		return true
+	case opAlternate:
+		for _, sub := range e.sub {
+			if sub.nullable() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func concat(e1, e2 *expr) *expr {
+	switch {
+	case e1 == nil || e2 == nil:
+		return nil
+	case e1.op == opEpsilon:
+		return e2
+	case e2.op == opEpsilon:
+		return e1
+	}
+	sub := make([]*expr, 0)
+	for _, e := range []*expr{e1, e2} {
+		if e.op == opConcat {
+			sub = append(sub, e.sub...)
+		} else {
+			sub = append(sub, e)
+		}
+	}
+	return &expr{op: opConcat, sub: sub}
+}
+
+func alternate(e1, e2 *expr) *expr {
+	switch {
+	case e1 == nil:
+		return e2
+	case e2 == nil:
+		return e1
+	case e1.op == opEpsilon && e2.nullable():
+		return e2
+	case e2.op == opEpsilon && e1.nullable():
+		return e1
+	}
+	sub := make([]*expr, 0)
+	seen := make(map[string]bool)
+	// the alternatives reading a single character are merged into one set,
+	// which takes the place of the first of them
+	chars, at := make([]Range, 0), -1
+	for _, e := range []*expr{e1, e2} {
+		subs := []*expr{e}
+		if e.op == opAlternate {
+			subs = e.sub
+		}
+		for _, s := range subs {
+			if ranges, ok := s.characters(); ok {
+				if at < 0 {
+					at = len(sub)
+					sub = append(sub, nil)
+				}
+				chars = append(chars, ranges...)
+			} else if !seen[s.String()] {
+				seen[s.String()] = true
+				sub = append(sub, s)
+			}
+		}
+	}
+	if at >= 0 {
+		sub[at] = characters(chars)
+	}
+	if len(sub) == 1 {
+		return sub[0]
+	}
+	return &expr{op: opAlternate, sub: sub}
+}
+
+// characters returns an expression reading any character of the ranges,
+// or nil if there is none. Characters past utf8.MaxRune are left out.
+func characters(ranges []Range) *expr {
+	merged := merge(ranges)
+	for len(merged) > 0 && merged[len(merged)-1].Lo > utf8.MaxRune {
+		merged = merged[:len(merged)-1]
+	}
+	if n := len(merged); n > 0 && merged[n-1].Hi > utf8.MaxRune {
+		merged[n-1].Hi = utf8.MaxRune
+	}
+	switch {
+	case len(merged) == 0:
+		return nil
+	case len(merged) == 1 && merged[0].Lo == merged[0].Hi:
+		return &expr{op: opLiteral, char: merged[0].Lo}
+	}
+	return &expr{op: opClass, ranges: merged}
+}
+
+// characters returns the ranges of the characters the expression reads, if
+// it reads a single one
+func (e *expr) characters() ([]Range, bool) {
+	switch e.op {
+	case opLiteral:
+		return []Range{{e.char, e.char}}, true
+	case opClass:
+		return e.ranges, true
+	}
+	return nil, false
+}
+
+// merge sorts the ranges and merges those that overlap or touch
+func merge(ranges []Range) []Range {
+	sorted := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Lo <= r.Hi {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Lo < sorted[j].Lo })
+	merged := make([]Range, 0, len(sorted))
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && int64(r.Lo) <= int64(merged[n-1].Hi)+1 {
+			if r.Hi > merged[n-1].Hi {
+				merged[n-1].Hi = r.Hi
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// complement returns the ranges of the valid characters that aren't in the
+// given sorted and merged ranges
+func complement(ranges []Range) []Range {
+	res := make([]Range, 0, len(ranges)+1)
+	next := rune(0)
+	for _, r := range ranges {
+		if next > utf8.MaxRune {
+			break
+		}
+		if r.Lo > next {
+			res = append(res, Range{next, r.Lo - 1})
+		}
+		if r.Hi >= utf8.MaxRune {
+			next = utf8.MaxRune + 1
+		} else if r.Hi >= next {
+			next = r.Hi + 1
+		}
+	}
+	if next <= utf8.MaxRune {
+		res = append(res, Range{next, utf8.MaxRune})
+	}
+	return res
+}
+
+func star(e *expr) *expr {
+	if e == nil || e.op == opEpsilon {
+		return epsilon
+	}
+	if e.op == opStar {
+		return e
+	}
+	if e.op == opAlternate {
+		// (|φ)* is the same as φ*
+		sub := make([]*expr, 0, len(e.sub))
+		for _, s := range e.sub {
+			if s.op != opEpsilon {
+				sub = append(sub, s)
+			}
+		}
+		if len(sub) == 1 {
+			return star(sub[0])
+		}
+		e = &expr{op: opAlternate, sub: sub}
+	}
+	return &expr{op: opStar, sub: []*expr{e}}
+}
+
+func (e *expr) String() string {
+	if e.op == opEpsilon {
+		return "()"
+	}
+	if e.text == "" {
+		var b strings.Builder
+		e.write(&b)
+		e.text = b.String()
+	}
+	return e.text
+}
+
+func (e *expr) precedence() int {
+	switch e.op {
+	case opAlternate:
+		return 0
+	case opConcat:
+		return 1
+	case opStar:
+		return 2
+	}
+	return 3
+}
+
+func (e *expr) write(b *strings.Builder) {
+	switch e.op {
+	case opEpsilon:
+		b.WriteString("()")
+	case opLiteral:
+		writeChar(b, e.char, metacharacters)
+	case opClass:
+		ranges := e.ranges
+		last := ranges[len(ranges)-1]
+		if ranges[0].Lo == 0 && last.Hi == utf8.MaxRune && len(ranges) == 1 {
+			b.WriteRune('.')
+			break
+		}
+		b.WriteRune('[')
+		// a set holding the first and the last characters is written as
+		// the negation of the others, which are fewer ranges
+		if ranges[0].Lo == 0 && last.Hi == utf8.MaxRune {
+			b.WriteRune('^')
+			ranges = complement(ranges)
+		}
+		for _, r := range ranges {
+			writeClassChar(b, r.Lo)
+			if r.Hi > r.Lo+1 {
+				b.WriteRune('-')
+			}
+			if r.Hi > r.Lo {
+				writeClassChar(b, r.Hi)
+			}
+		}
+		b.WriteRune(']')
+	case opConcat:
+		for _, sub := range e.sub {
+			sub.writeWrapped(b, e.precedence()+1)
+		}
+	case opAlternate:
+		for i, sub := range e.sub {
+			if i > 0 {
+				b.WriteRune('|')
+			}
+			// the empty word is written as an empty alternative
+			if sub.op != opEpsilon {
+				sub.writeWrapped(b, e.precedence()+1)
+			}
+		}
+	case opStar:
+		e.sub[0].writeWrapped(b, e.precedence()+1)
+		b.WriteRune('*')
+	}
+}
+
+func (e *expr) writeWrapped(b *strings.Builder, precedence int) {
+	if e.op != opEpsilon && e.precedence() < precedence {
+		b.WriteRune('(')
+		e.write(b)
+		b.WriteRune(')')
+		return
+	}
+	e.write(b)
+}
+
+func writeClassChar(b *strings.Builder, char rune) {
+	writeChar(b, char, classMetacharacters)
+}
+
+// writeChar writes a character, escaping it if it's one of the given
+// metacharacters, or by its code if it can't be printed
+func writeChar(b *strings.Builder, char rune, metacharacters string) {
+	if !unicode.IsPrint(char) {
+		fmt.Fprintf(b, `\x{%x}`, char)
+		return
+	}
+	if strings.ContainsRune(metacharacters, char) {
+		b.WriteRune('\\')
+	}
+	b.WriteRune(char)
+}
@@ -0,0 +1,73 @@
+package gnfa
+
+import "testing"
+
+func TestToRegex(t *testing.T) {
+	// 1 -a-> 2 -b-> 2 -c-> 3, with 1 and 3 final
+	g := New(3)
+	g.AddTransition(1, 2, 'a')
+	g.AddTransition(2, 2, 'b')
+	g.AddTransition(2, 3, 'c')
+	if re, ok := g.ToRegex(1, []int{1, 3}); !ok || re != "|ab*c" {
+		t.Errorf("Wrong regex: %q", re)
+	}
+
+	// states that lead nowhere are dropped
+	g = New(3)
+	g.AddTransition(1, 2, '*')
+	g.AddTransition(1, 3, 'x')
+	g.AddEpsilon(2, 2)
+	if re, ok := g.ToRegex(1, []int{2}); !ok || re != `\*` {
+		t.Errorf("Wrong regex: %q", re)
+	}
+
+	// ranges are written as classes
+	g = New(2)
+	g.AddRange(1, 2, 'a', 'z')
+	g.AddRange(2, 2, '-', '-')
+	g.AddRange(2, 2, ']', '^')
+	if re, ok := g.ToRegex(1, []int{2}); !ok || re != `[a-z][\-\]\^]*` {
+		t.Errorf("Wrong regex: %q", re)
+	}
+
+	// single characters are merged into a class, a negated class or '.',
+	// and those that can't be printed are written by their codes
+	type Test struct {
+		Ranges []Range
+		Except []Range
+		Re     string
+	}
+	tests := []Test{
+		Test{[]Range{{'a', 'a'}, {'c', 'd'}, {'b', 'b'}}, nil, "[a-d]"},
+		Test{[]Range{{'x', 'x'}}, []Range{{'a', 'c'}, {'x', 'z'}}, "[^a-cyz]"},
+		Test{nil, []Range{{'a', 'a'}}, "[^a]"},
+		Test{[]Range{{'a', 'a'}}, []Range{{'a', 'a'}}, "."},
+		Test{[]Range{{0, 0}, {'\n', '\n'}}, nil, `[\x{0}\x{a}]`},
+		Test{[]Range{{'a', 0x7FFFFFFF}}, nil, `[a-\x{10ffff}]`},
+	}
+	for _, test := range tests {
+		g = New(2)
+		for _, r := range test.Ranges {
+			g.AddRange(1, 2, r.Lo, r.Hi)
+		}
+		if test.Except != nil {
+			g.AddExcept(1, 2, test.Except)
+		}
+		if re, ok := g.ToRegex(1, []int{2}); !ok || re != test.Re {
+			t.Errorf("Wrong regex: %q, expected %q", re, test.Re)
+		}
+	}
+
+	// no character is left outside of every valid one
+	g = New(2)
+	g.AddExcept(1, 2, []Range{{0, 'a'}, {'b', 0x10FFFF}})
+	g.AddTransition(1, 2, 'a')
+	if re, ok := g.ToRegex(1, []int{2}); !ok || re != "a" {
+		t.Errorf("Wrong regex: %q", re)
+	}
+	g = New(2)
+	g.AddTransition(1, 2, 'a')
+	if _, ok := g.ToRegex(2, []int{1}); ok {
+		t.Errorf("There should be no regex when no final state is reachable")
+	}
+}
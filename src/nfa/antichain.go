@@ -0,0 +1,178 @@
+package nfa
+
+import (
+	"dfa"
+	"queue"
+	"stateset"
+	"unicode/utf8"
+)
+
+// Includes checks whether every word accepted by a is also accepted by b.
+// If not, it also returns a word accepted by a but not by b. The
+// characters that an alphabet leaves out are read through Other.
+//
+// It uses the antichain algorithm of De Wulf, Doyen, Henzinger and Raskin,
+// "Antichains: a new algorithm for checking universality of finite
+// automata": it explores pairs of a state of a and a set of states of b
+// reached by the same word, but drops every pair (p, S) when (p, S') has
+// already been seen for some S' ⊆ S, as any word leading from (p, S) to a
+// counterexample also does so from (p, S'). This usually keeps far fewer
+// sets than the subset construction of b would.
+func Includes(a, b NFA) (bool, string) {
+	a = a.RemoveEpsilons()
+	is_final_a := make([]bool, a.NumStates+1)
+	for _, node := range a.FinalStates {
+		is_final_a[node] = true
+	}
+	is_final_b := make([]bool, b.NumStates+1)
+	for _, node := range b.FinalStates {
+		is_final_b[node] = true
+	}
+	// the symbols split so that each stands for whole symbols of both
+	// alphabets, and a character to stand for Other in counterexamples
+	alphabet, ends := refine(&a, &b, true)
+	other, ok := outside(alphabet, ends)
+	// Other comes last, so that counterexamples use the characters of the
+	// alphabets first, and it's left out if it stands for no valid
+	// character
+	symbols := append(make([]rune, 0, len(alphabet)), alphabet[1:]...)
+	if ok {
+		symbols = append(symbols, Other)
+	}
+	closure := stateset.New(b.NumStates + 1)
+	stack := make([]int, 0, b.NumStates+1)
+
+	// every pair explored is kept, along with the way it was reached, so
+	// that counterexamples can be rebuilt
+	type pair struct {
+		state   int
+		set     []int
+		parent  int
+		char    rune
+		removed bool
+	}
+	pairs := make([]pair, 0)
+	// antichain[p] lists the pairs (p, S) whose sets S are minimal
+	antichain := make(map[int][]int)
+	q := queue.New(1)
+	add := func(state int, set []int, parent int, char rune) {
+		for _, id := range antichain[state] {
+			if isSubset(pairs[id].set, set) {
+				return
+			}
+		}
+		kept := antichain[state][:0]
+		for _, id := range antichain[state] {
+			if isSubset(set, pairs[id].set) {
+				pairs[id].removed = true
+			} else {
+				kept = append(kept, id)
+			}
+		}
+		pairs = append(pairs, pair{state, set, parent, char, false})
+		antichain[state] = append(kept, len(pairs)-1)
+		q.Push(len(pairs) - 1)
+	}
+
+	closure.Clear()
+	b.addClosure(closure, b.EntryState, stack)
+	add(a.EntryState, sortedElements(closure), -1, 0)
+	for !q.Empty() {
+		id, _ := q.Pop()
+		current := pairs[id]
+		if current.removed {
+			continue
+		}
+		if is_final_a[current.state] && !anyFinal(current.set, is_final_b) {
+			word := make([]rune, 0)
+			for ; pairs[id].parent >= 0; id = pairs[id].parent {
+				word = append(word, pairs[id].char)
+			}
+			for i, j := 0, len(word)-1; i < j; i, j = i+1, j-1 {
+				word[i], word[j] = word[j], word[i]
+			}
+			return false, string(word)
+		}
+		for _, symbol := range symbols {
+			targets := a.Graph[current.state][a.symbol(symbol)]
+			if len(targets) == 0 {
+				continue
+			}
+			closure.Clear()
+			for _, node := range current.set {
+				for _, neighbour := range b.Graph[node][b.symbol(symbol)] {
+					b.addClosure(closure, neighbour, stack)
+				}
+			}
+			set := sortedElements(closure)
+			character := symbol
+			if symbol == Other {
+				character = other
+			}
+			for _, neighbour := range targets {
+				add(neighbour, set, id, character)
+			}
+		}
+	}
+	return true, ""
+}
+
+// Equivalent checks whether two NFAs accept the same words. If not, it
+// also returns a word accepted by only one of them.
+func Equivalent(a, b NFA) (bool, string) {
+	if ok, word := Includes(a, b); !ok {
+		return false, word
+	}
+	return Includes(b, a)
+}
+
+// isSubset returns true if every element of the sorted slice a is in the
+// sorted slice b
+func isSubset(a, b []int) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	j := 0
+	for _, x := range a {
+		for j < len(b) && b[j] < x {
+			j++
+		}
+		if j == len(b) || b[j] != x {
+			return false
+		}
+		j++
+	}
+	return true
+}
+
+// outside returns a valid character that no symbol of the alphabet but
+// Other stands for, preferably from 'a' on. ok is false if there is none.
+func outside(alphabet []rune, ends map[rune]rune) (char rune, ok bool) {
+	for _, r := range []Range{{'a', utf8.MaxRune}, {0, 'a' - 1}} {
+		for char = r.Lo; char <= r.Hi; {
+			if !utf8.ValidRune(char) {
+				// skip the surrogates
+				char++
+				continue
+			}
+			symbol := dfa.Symbol(alphabet, ends, char)
+			if symbol == Other {
+				return char, true
+			}
+			if hi, ok := ends[symbol]; ok {
+				char = hi
+			}
+			char++
+		}
+	}
+	return 0, false
+}
+
+func anyFinal(set []int, is_final []bool) bool {
+	for _, node := range set {
+		if is_final[node] {
+			return true
+		}
+	}
+	return false
+}
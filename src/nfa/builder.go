@@ -0,0 +1,138 @@
+package nfa
+
+// Builder puts an NFA together out of fragments. Unlike Concat, Either and
+// Star, which copy the NFAs they are given, all the fragments of a Builder
+// live in the same NFA, so combining two of them only adds a couple of
+// states and λ-transitions.
+//
+// Every fragment must be used at most once, as the combinators link the
+// fragments they are given instead of copying them.
+type Builder struct {
+	nfa NFA
+}
+
+// Fragment is a piece of the NFA being built by a Builder. It is entered
+// through its Entry state and a word it matches leads to its Exit state.
+type Fragment struct {
+	Entry, Exit int
+}
+
+// NewBuilder returns a Builder with no states
+func NewBuilder() *Builder {
+	return &Builder{New()}
+}
+
+func (b *Builder) state() int {
+	b.nfa.NumStates++
+	return b.nfa.NumStates
+}
+
+func (b *Builder) transition(from, to int, char rune) {
+	if _, ok := b.nfa.Graph[from]; !ok {
+		b.nfa.Graph[from] = make(map[rune][]int)
+	}
+	b.nfa.Graph[from][char] = append(b.nfa.Graph[from][char], to)
+	b.nfa.NumTransitions++
+}
+
+func (b *Builder) epsilon(from, to int) {
+	b.nfa.Epsilon[from] = append(b.nfa.Epsilon[from], to)
+	b.nfa.NumTransitions++
+}
+
+// Empty returns a fragment that only matches the empty word
+func (b *Builder) Empty() Fragment {
+	state := b.state()
+	return Fragment{state, state}
+}
+
+// Never returns a fragment that doesn't match anything
+func (b *Builder) Never() Fragment {
+	return Fragment{b.state(), b.state()}
+}
+
+// Rune returns a fragment that only matches the given character
+func (b *Builder) Rune(r rune) Fragment {
+	f := Fragment{b.state(), b.state()}
+	b.transition(f.Entry, f.Exit, r)
+	return f
+}
+
+// Literal returns a fragment that only matches the given word
+func (b *Builder) Literal(s string) Fragment {
+	f := b.Empty()
+	for _, char := range s {
+		next := b.state()
+		b.transition(f.Exit, next, char)
+		f.Exit = next
+	}
+	return f
+}
+
+// Class returns a fragment that matches any single character from the
+// given ranges. Like Class, it panics if they hold more than MaxClassSize
+// characters.
+func (b *Builder) Class(ranges []Range) Fragment {
+	f := Fragment{b.state(), b.state()}
+	for _, char := range classCharacters(ranges) {
+		b.transition(f.Entry, f.Exit, char)
+	}
+	return f
+}
+
+// Concat joins two fragments. If f1 matched φ and f2 matched ψ, the
+// resulting fragment will match φψ
+func (b *Builder) Concat(f1, f2 Fragment) Fragment {
+	b.epsilon(f1.Exit, f2.Entry)
+	return Fragment{f1.Entry, f2.Exit}
+}
+
+// Either joins two fragments. If f1 matched φ and f2 matched ψ, the
+// resulting fragment will match either of φ and ψ
+func (b *Builder) Either(f1, f2 Fragment) Fragment {
+	f := Fragment{b.state(), b.state()}
+	b.epsilon(f.Entry, f1.Entry)
+	b.epsilon(f.Entry, f2.Entry)
+	b.epsilon(f1.Exit, f.Exit)
+	b.epsilon(f2.Exit, f.Exit)
+	return f
+}
+
+// Star repeats a fragment. If f1 matched φ, the resulting fragment will
+// match zero or more occurrences of φ
+func (b *Builder) Star(f1 Fragment) Fragment {
+	state := b.state()
+	b.epsilon(state, f1.Entry)
+	b.epsilon(f1.Exit, state)
+	return Fragment{state, state}
+}
+
+// Build returns the NFA matching the given fragment. The Builder shouldn't
+// be used afterwards.
+func (b *Builder) Build(f Fragment) NFA {
+	res := b.nfa
+	res.EntryState = f.Entry
+	res.FinalStates = []int{f.Exit}
+	b.nfa = New()
+	return res
+}
+
+// BuildLabelled returns an NFA matching any of the given fragments, whose
+// final states are labelled with the index of the fragment they end.
+// The Builder shouldn't be used afterwards.
+func (b *Builder) BuildLabelled(fragments []Fragment) NFA {
+	entry := b.state()
+	res := b.nfa
+	res.EntryState = entry
+	res.FinalStates = make([]int, 0, len(fragments))
+	for i, f := range fragments {
+		res.Epsilon[entry] = append(res.Epsilon[entry], f.Entry)
+		res.NumTransitions++
+		if len(res.Labels[f.Exit]) == 0 {
+			res.FinalStates = append(res.FinalStates, f.Exit)
+		}
+		res.Labels[f.Exit] = append(res.Labels[f.Exit], i)
+	}
+	b.nfa = New()
+	return res
+}
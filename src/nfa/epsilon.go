@@ -0,0 +1,89 @@
+package nfa
+
+import (
+	"stateset"
+)
+
+// EpsilonClosure returns, in increasing order, the given states along with
+// every state reachable from them through λ-transitions
+func (n *NFA) EpsilonClosure(states []int) []int {
+	set := stateset.New(n.NumStates + 1)
+	stack := make([]int, 0, n.NumStates+1)
+	for _, state := range states {
+		n.addClosure(set, state, stack)
+	}
+	return sortedElements(set)
+}
+
+// RemoveEpsilons returns an NFA without λ-transitions that accepts the same
+// language. It keeps the same states: each state gets the transitions of
+// every state in its λ-closure, and becomes final if one of them is.
+func (n *NFA) RemoveEpsilons() NFA {
+	res := New()
+	res.NumStates = n.NumStates
+	res.EntryState = n.EntryState
+	res.Alphabet, res.Ends = copyAlphabet(n.Alphabet), copyEnds(n.Ends)
+	is_final := make([]bool, n.NumStates+1)
+	for _, node := range n.FinalStates {
+		is_final[node] = true
+	}
+	set := stateset.New(n.NumStates + 1)
+	stack := make([]int, 0, n.NumStates+1)
+	added := stateset.New(n.NumStates + 1)
+	for i := 0; i <= n.NumStates; i++ {
+		if _, ok := n.Graph[i]; !ok && len(n.Epsilon[i]) == 0 && !is_final[i] {
+			continue
+		}
+		set.Clear()
+		n.addClosure(set, i, stack)
+		closure := sortedElements(set)
+		for _, node := range closure {
+			if is_final[node] {
+				res.FinalStates = append(res.FinalStates, i)
+				break
+			}
+		}
+		if labels := n.labelsOf(closure); len(labels) > 0 {
+			res.Labels[i] = labels
+		}
+		characters := make(map[rune]bool)
+		for _, node := range closure {
+			for character, _ := range n.Graph[node] {
+				characters[character] = true
+			}
+		}
+		for character, _ := range characters {
+			added.Clear()
+			for _, node := range closure {
+				for _, neighbour := range n.Graph[node][character] {
+					if added.Add(neighbour) {
+						if _, ok := res.Graph[i]; !ok {
+							res.Graph[i] = make(map[rune][]int)
+						}
+						res.Graph[i][character] = append(res.Graph[i][character], neighbour)
+						res.NumTransitions++
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+// addClosure adds the state to the set, along with every state reachable
+// from it through λ-transitions. The stack is only used as scratch space.
+func (n *NFA) addClosure(set *stateset.Set, state int, stack []int) {
+	if !set.Add(state) {
+		return
+	}
+	stack = append(stack[:0], state)
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, neighbour := range n.Epsilon[node] {
+			if set.Add(neighbour) {
+				stack = append(stack, neighbour)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package nfa
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Read reads an NFA written in the following format, one item per line:
+//
+//	states [NumStates int]
+//	entry [state int]...
+//	final [state int]...
+//	[from_state int] [to_state int] [character]
+//	[from_state int] [to_state int] eps
+//	alphabet [character]...
+//
+// Characters are written as Go rune literals, such as 'a', ' ' or '\n', or
+// as other for Other, and eps stands for a λ-transition. The alphabet line
+// is optional; a range of characters is written in it as 'a'-'z', and the
+// transitions on the range are written on its first character. Empty lines
+// and lines starting with # are ignored. If there are several entry states,
+// a new entry state is added, with λ-transitions to each of them.
+func Read(r io.Reader) (NFA, error) {
+	res := New()
+	entries := make([]int, 0, 1)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case "states":
+			if len(fields) != 2 {
+				return New(), fmt.Errorf("Line %d: expected the number of states", line)
+			}
+			var err error
+			if res.NumStates, err = strconv.Atoi(fields[1]); err != nil {
+				return New(), fmt.Errorf("Line %d: %v", line, err)
+			}
+		case "entry", "final":
+			states, err := readStates(fields[1:], res.NumStates)
+			if err != nil {
+				return New(), fmt.Errorf("Line %d: %v", line, err)
+			}
+			if fields[0] == "entry" {
+				entries = append(entries, states...)
+			} else {
+				res.FinalStates = append(res.FinalStates, states...)
+			}
+		case "alphabet":
+			alphabet, ends, err := readCharacters(strings.TrimSpace(text[len("alphabet"):]))
+			if err != nil {
+				return New(), fmt.Errorf("Line %d: %v", line, err)
+			}
+			sort.Slice(alphabet, func(i, j int) bool { return alphabet[i] < alphabet[j] })
+			res.Alphabet, res.Ends = alphabet, ends
+		default:
+			if err := res.readTransition(text); err != nil {
+				return New(), fmt.Errorf("Line %d: %v", line, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return New(), err
+	}
+
+	switch len(entries) {
+	case 0:
+		return New(), fmt.Errorf("Missing entry state")
+	case 1:
+		res.EntryState = entries[0]
+	default:
+		res.NumStates++
+		res.EntryState = res.NumStates
+		res.Epsilon[res.EntryState] = entries
+		res.NumTransitions += len(entries)
+	}
+	return res, nil
+}
+
+func readStates(fields []string, numStates int) ([]int, error) {
+	res := make([]int, 0, len(fields))
+	for _, field := range fields {
+		state, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		if state < 0 || state > numStates {
+			return nil, fmt.Errorf("State %d out of range", state)
+		}
+		res = append(res, state)
+	}
+	return res, nil
+}
+
+// readTransition reads a line such as 1 2 'a' or 1 2 eps
+func (n *NFA) readTransition(text string) error {
+	fields := strings.Fields(text)
+	if len(fields) < 3 {
+		return fmt.Errorf("Expected a transition, got %q", text)
+	}
+	states, err := readStates(fields[:2], n.NumStates)
+	if err != nil {
+		return err
+	}
+	from, to := states[0], states[1]
+	// the only character written with a space in it is ' '
+	label := strings.Join(fields[2:], " ")
+	if label == "eps" {
+		n.Epsilon[from] = append(n.Epsilon[from], to)
+		n.NumTransitions++
+		return nil
+	}
+	chars, ends, err := readCharacters(label)
+	if err != nil || len(chars) != 1 || ends != nil {
+		return fmt.Errorf("Invalid character %s", label)
+	}
+	char := chars[0]
+	if _, ok := n.Graph[from]; !ok {
+		n.Graph[from] = make(map[rune][]int)
+	}
+	n.Graph[from][char] = append(n.Graph[from][char], to)
+	n.NumTransitions++
+	return nil
+}
+
+// readCharacters reads a list of rune literals, ranges such as 'a'-'z' and
+// other, separated by spaces. ends gives the last character of each range.
+func readCharacters(text string) (chars []rune, ends map[rune]rune, err error) {
+	chars = make([]rune, 0)
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimSpace(text) {
+		if strings.HasPrefix(text, "other") {
+			chars = append(chars, Other)
+			text = text[len("other"):]
+			continue
+		}
+		var lo, hi rune
+		if lo, text, err = readCharacter(text); err != nil {
+			return nil, nil, err
+		}
+		chars = append(chars, lo)
+		if !strings.HasPrefix(text, "-") {
+			continue
+		}
+		if hi, text, err = readCharacter(text[1:]); err != nil {
+			return nil, nil, err
+		}
+		if hi <= lo {
+			return nil, nil, fmt.Errorf("Invalid range %s-%s", strconv.QuoteRune(lo), strconv.QuoteRune(hi))
+		}
+		if ends == nil {
+			ends = make(map[rune]rune)
+		}
+		ends[lo] = hi
+	}
+	return chars, ends, nil
+}
+
+// readCharacter reads the rune literal the text starts with, and returns
+// the rest of the text
+func readCharacter(text string) (rune, string, error) {
+	literal, err := strconv.QuotedPrefix(text)
+	if err != nil || literal[0] != '\'' {
+		return 0, "", fmt.Errorf("Invalid character %s", text)
+	}
+	unquoted, _ := strconv.Unquote(literal)
+	return []rune(unquoted)[0], text[len(literal):], nil
+}
+
+// writeCharacter writes a character the way readCharacters reads it
+func writeCharacter(char rune) string {
+	if char == Other {
+		return "other"
+	}
+	return strconv.QuoteRune(char)
+}
+
+// Write writes the NFA in the format understood by Read. Transitions are
+// sorted, so that equal NFAs give equal texts.
+func (n *NFA) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "states %d\n", n.NumStates)
+	fmt.Fprintf(b, "entry %d\n", n.EntryState)
+	finals := make([]int, len(n.FinalStates))
+	copy(finals, n.FinalStates)
+	sort.Ints(finals)
+	b.WriteString("final")
+	for _, state := range finals {
+		fmt.Fprintf(b, " %d", state)
+	}
+	b.WriteString("\n")
+	if n.Alphabet != nil {
+		b.WriteString("alphabet")
+		for _, char := range n.Alphabet {
+			fmt.Fprintf(b, " %s", writeCharacter(char))
+			if hi, ok := n.Ends[char]; ok {
+				fmt.Fprintf(b, "-%s", writeCharacter(hi))
+			}
+		}
+		b.WriteString("\n")
+	}
+	for node := 0; node <= n.NumStates; node++ {
+		epsilon := make([]int, len(n.Epsilon[node]))
+		copy(epsilon, n.Epsilon[node])
+		sort.Ints(epsilon)
+		for _, neighbour := range epsilon {
+			fmt.Fprintf(b, "%d %d eps\n", node, neighbour)
+		}
+		characters := make([]rune, 0, len(n.Graph[node]))
+		for character, _ := range n.Graph[node] {
+			characters = append(characters, character)
+		}
+		sort.Slice(characters, func(i, j int) bool { return characters[i] < characters[j] })
+		for _, character := range characters {
+			neighbours := make([]int, len(n.Graph[node][character]))
+			copy(neighbours, n.Graph[node][character])
+			sort.Ints(neighbours)
+			for _, neighbour := range neighbours {
+				fmt.Fprintf(b, "%d %d %s\n", node, neighbour, writeCharacter(character))
+			}
+		}
+	}
+	return b.Flush()
+}
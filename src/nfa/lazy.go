@@ -0,0 +1,191 @@
+package nfa
+
+import (
+	"stateset"
+	"unicode/utf8"
+)
+
+const (
+	// rough number of bytes used by a cached DFA state, on top of the NFA
+	// states it is made of
+	lazyStateCost = 96
+	// rough number of bytes used by a cached transition
+	lazyTransitionCost = 16
+	// if a single word needs more cache flushes than this, the rest of it is
+	// matched by simulating the NFA, as building states no longer pays off
+	lazyMaxFlushes = 8
+)
+
+// LazyDFA matches words against an NFA by building the states of the
+// equivalent DFA only when a word reaches them, the way RE2 does. The states
+// and transitions built so far are cached, as long as they fit in the
+// memory budget; when they don't, the cache is flushed and started over.
+type LazyDFA struct {
+	// Number of times the cache was flushed because it went over budget
+	Flushes int
+
+	// Number of words that were matched by simulating the NFA, because the
+	// cache kept going over budget
+	Fallbacks int
+
+	nfa      *NFA
+	budget   int
+	used     int
+	is_final []bool
+
+	// the cached DFA states: their sets of NFA states, whether they are
+	// final, and the transitions found so far
+	sets  [][]int
+	final []bool
+	next  []map[rune]int
+	ids   map[string]int
+	start int
+
+	closure *stateset.Set
+	stack   []int
+}
+
+// NewLazyDFA returns a lazy DFA for the given NFA, which keeps at most about
+// budget bytes worth of DFA states and transitions in its cache.
+func NewLazyDFA(n *NFA, budget int) *LazyDFA {
+	l := &LazyDFA{
+		nfa:      n,
+		budget:   budget,
+		is_final: make([]bool, n.NumStates+1),
+		closure:  stateset.New(n.NumStates + 1),
+		stack:    make([]int, 0, n.NumStates+1),
+	}
+	for _, node := range n.FinalStates {
+		l.is_final[node] = true
+	}
+	l.flush()
+	return l
+}
+
+// CachedStates returns the number of DFA states currently in the cache.
+func (l *LazyDFA) CachedStates() int {
+	return len(l.sets)
+}
+
+// Check checks whether the NFA accepts a given word
+func (l *LazyDFA) Check(word string) bool {
+	if l.start < 0 {
+		l.closure.Clear()
+		l.nfa.addClosure(l.closure, l.nfa.EntryState, l.stack)
+		set := sortedElements(l.closure)
+		if l.start = l.add(set); l.start < 0 {
+			return l.fallback(set, word)
+		}
+	}
+	state := l.start
+	flushes := 0
+	for pos := 0; pos < len(word); {
+		char, size := utf8.DecodeRuneInString(word[pos:])
+		pos += size
+		next, ok := l.next[state][char]
+		if !ok {
+			set := l.successor(state, char)
+			if next, ok = l.link(state, char, set); !ok {
+				// start over with the current state, so that the
+				// transition is cached again
+				from := l.sets[state]
+				l.flush()
+				l.Flushes++
+				flushes++
+				if flushes > lazyMaxFlushes {
+					return l.fallback(set, word[pos:])
+				}
+				if state = l.add(from); state >= 0 {
+					next, ok = l.link(state, char, set)
+				}
+				if !ok {
+					return l.fallback(set, word[pos:])
+				}
+			}
+		}
+		state = next
+		if len(l.sets[state]) == 0 {
+			return false
+		}
+	}
+	return l.final[state]
+}
+
+// successor returns the set of NFA states reached from the given DFA state
+// by reading a character
+func (l *LazyDFA) successor(state int, char rune) []int {
+	l.closure.Clear()
+	char = l.nfa.symbol(char)
+	for _, node := range l.sets[state] {
+		for _, neighbour := range l.nfa.Graph[node][char] {
+			l.nfa.addClosure(l.closure, neighbour, l.stack)
+		}
+	}
+	return sortedElements(l.closure)
+}
+
+// link returns the DFA state for the set of NFA states reached from a state
+// by reading a character, and caches the transition to it. It returns false
+// if there is no room left for them.
+func (l *LazyDFA) link(state int, char rune, set []int) (int, bool) {
+	// the transition is accounted for before the state is added, so that
+	// both fit in the budget
+	if l.used+lazyTransitionCost > l.budget {
+		return -1, false
+	}
+	l.used += lazyTransitionCost
+	next := l.add(set)
+	if next < 0 {
+		l.used -= lazyTransitionCost
+		return -1, false
+	}
+	l.next[state][char] = next
+	return next, true
+}
+
+// add returns the DFA state for a set of NFA states, adding it to the cache
+// if needed. It returns -1 if there is no room left for it.
+func (l *LazyDFA) add(set []int) int {
+	key := setKey(set)
+	if id, ok := l.ids[key]; ok {
+		return id
+	}
+	cost := lazyStateCost + 8*len(set) + len(key)
+	if l.used+cost > l.budget {
+		return -1
+	}
+	l.used += cost
+	final := false
+	for _, node := range set {
+		if l.is_final[node] {
+			final = true
+			break
+		}
+	}
+	l.sets = append(l.sets, set)
+	l.final = append(l.final, final)
+	l.next = append(l.next, make(map[rune]int))
+	l.ids[key] = len(l.sets) - 1
+	return len(l.sets) - 1
+}
+
+// flush empties the cache
+func (l *LazyDFA) flush() {
+	l.sets = make([][]int, 0)
+	l.final = make([]bool, 0)
+	l.next = make([]map[rune]int, 0)
+	l.ids = make(map[string]int)
+	l.start = -1
+	l.used = 0
+}
+
+// fallback matches the rest of a word by simulating the NFA, starting from
+// the given set of states
+func (l *LazyDFA) fallback(set []int, rest string) bool {
+	l.Fallbacks++
+	current := stateset.New(l.nfa.NumStates + 1)
+	for _, node := range set {
+		current.Add(node)
+	}
+	return l.nfa.run(current, rest)
+}
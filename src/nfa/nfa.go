@@ -1,16 +1,85 @@
 package nfa
 
 import (
+	"context"
 	"dfa"
+	"fmt"
+	"gnfa"
+	"io"
 	"queue"
+	"sort"
+	"stateset"
+	"strconv"
 )
 
+// NFA represents a Nondeterministic Finite Automaton with λ-transitions
 type NFA struct {
-	dfa.DFA
+	// Number of states in the NFA
+	NumStates int
+
+	// Number of transitions in the NFA, λ-transitions included
+	NumTransitions int
+
+	// NFA Entry point
+	EntryState int
+
+	// NFA Final states
+	FinalStates []int
+
+	// Graph[state][character] lists the states reached by reading the
+	// character
+	Graph map[int]map[rune][]int
+
+	// Epsilon[state] lists the states reached through λ-transitions, without
+	// reading anything
+	Epsilon map[int][]int
+
+	// Labels of the final states, in increasing order, such as the rules
+	// they accept on behalf of. ToDFA gives every DFA state the labels of
+	// all the final NFA states it is made of.
+	Labels map[int][]int
+
+	// Alphabet lists, in increasing order, the characters the NFA reads,
+	// or the first ones of the ranges it reads, with Other first if it
+	// reads any character. If it's nil, the
+	// alphabet is made of the characters on the transitions.
+	Alphabet []rune
+
+	// Ends gives, for the characters of the alphabet that stand for a
+	// range of characters, the last one of the range, as for a DFA
+	Ends map[rune]rune
 }
 
+// Other stands, in the alphabet and the transitions of an NFA, for all the
+// characters that aren't in its alphabet, as it does for a DFA
+const Other = dfa.Other
+
+// New is an empty constructor for an NFA. Returns a null NFA (zero states,
+// zero transitions)
 func New() NFA {
-	return NFA{dfa.New()}
+	return NFA{0, 0, 0, make([]int, 0), make(map[int]map[rune][]int), make(map[int][]int), make(map[int][]int), nil, nil}
+}
+
+// FromDFA returns an NFA that accepts the same language as the DFA
+func FromDFA(d dfa.DFA) NFA {
+	res := New()
+	res.NumStates = d.NumStates
+	res.NumTransitions = d.NumTransitions
+	res.EntryState = d.EntryState
+	res.FinalStates = make([]int, len(d.FinalStates))
+	copy(res.FinalStates, d.FinalStates)
+	for node, _ := range d.Graph {
+		res.Graph[node] = make(map[rune][]int)
+		for character, neighbour := range d.Graph[node] {
+			res.Graph[node][character] = []int{neighbour}
+		}
+	}
+	for node, labels := range d.Labels {
+		res.Labels[node] = make([]int, len(labels))
+		copy(res.Labels[node], labels)
+	}
+	res.Alphabet, res.Ends = copyAlphabet(d.Alphabet), copyEnds(d.Ends)
+	return res
 }
 
 func Copy(n NFA) NFA {
@@ -29,6 +98,197 @@ func Copy(n NFA) NFA {
 			}
 		}
 	}
+	for node, neighbours := range n.Epsilon {
+		res.Epsilon[node] = make([]int, len(neighbours))
+		copy(res.Epsilon[node], neighbours)
+	}
+	for node, labels := range n.Labels {
+		res.Labels[node] = make([]int, len(labels))
+		copy(res.Labels[node], labels)
+	}
+	res.Alphabet, res.Ends = copyAlphabet(n.Alphabet), copyEnds(n.Ends)
+	return res
+}
+
+// Process reads an NFA from a Reader, in the same format as DFA.Process.
+// Transitions on the λ character are read as λ-transitions.
+func (n *NFA) Process(r io.Reader) {
+	var a, b int
+	var c rune
+	var NumStates, state int
+
+	fmt.Fscanf(r, "%d %d\n", &n.NumStates, &n.NumTransitions)
+	for i := 0; i < n.NumTransitions; i++ {
+		_, err := fmt.Fscanf(r, "%d %d %c\n", &a, &b, &c)
+		if err != nil {
+			panic(err)
+		}
+		if c == 'λ' {
+			n.Epsilon[a] = append(n.Epsilon[a], b)
+			continue
+		}
+		if _, ok := n.Graph[a]; !ok {
+			n.Graph[a] = make(map[rune][]int)
+		}
+		n.Graph[a][c] = append(n.Graph[a][c], b)
+	}
+
+	fmt.Fscan(r, &n.EntryState, &NumStates)
+	for i := 0; i < NumStates; i++ {
+		fmt.Fscan(r, &state)
+		n.FinalStates = append(n.FinalStates, state)
+	}
+}
+
+// Prints the NFA, using the same format it uses to read it
+func (n *NFA) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d %d\n", n.NumStates, n.NumTransitions)
+	for i := 1; i <= n.NumStates; i++ {
+		for character, nodes := range n.Graph[i] {
+			for _, neighbour := range nodes {
+				fmt.Fprintf(w, "%d %d %c\n", i, neighbour, character)
+			}
+		}
+		for _, neighbour := range n.Epsilon[i] {
+			fmt.Fprintf(w, "%d %d λ\n", i, neighbour)
+		}
+	}
+	fmt.Fprintf(w, "%d\n", n.EntryState)
+	fmt.Fprintf(w, "%d ", len(n.FinalStates))
+	for _, state := range n.FinalStates {
+		fmt.Fprintf(w, "%d ", state)
+	}
+}
+
+// Returns true if the state is a final NFA state
+func (n *NFA) IsFinal(state int) bool {
+	for _, node := range n.FinalStates {
+		if node == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty returns an NFA that only matches the empty word
+func Empty() NFA {
+	res := New()
+	res.NumStates = 1
+	res.EntryState = 1
+	res.FinalStates = []int{1}
+	return res
+}
+
+// Never returns an NFA that doesn't match anything
+func Never() NFA {
+	res := New()
+	res.NumStates = 1
+	res.EntryState = 1
+	return res
+}
+
+// Rune returns an NFA that only matches the given character
+func Rune(r rune) NFA {
+	return Class([]Range{{r, r}})
+}
+
+// Literal returns an NFA that only matches the given word
+func Literal(s string) NFA {
+	res := Empty()
+	for _, char := range s {
+		res.Graph[res.NumStates] = map[rune][]int{char: []int{res.NumStates + 1}}
+		res.NumStates++
+		res.NumTransitions++
+	}
+	res.FinalStates = []int{res.NumStates}
+	return res
+}
+
+// Range is an interval of characters, both ends included
+type Range struct {
+	Lo, Hi rune
+}
+
+// Class returns an NFA that matches any single character from the given
+// ranges. Every character gets its own transition, so it panics if the
+// ranges hold more than MaxClassSize characters.
+func Class(ranges []Range) NFA {
+	res := New()
+	res.NumStates = 2
+	res.EntryState = 1
+	res.FinalStates = []int{2}
+	res.Graph[1] = make(map[rune][]int)
+	for _, char := range classCharacters(ranges) {
+		res.Graph[1][char] = []int{2}
+		res.NumTransitions++
+	}
+	return res
+}
+
+// MaxClassSize is the largest number of characters a class built by Class
+// or Builder.Class may hold
+const MaxClassSize = 1 << 16
+
+// classCharacters returns the distinct characters of the ranges, in
+// increasing order. It panics if there are more than MaxClassSize of them.
+func classCharacters(ranges []Range) []rune {
+	sorted := make([]Range, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Lo < sorted[j].Lo })
+	res := make([]rune, 0)
+	for _, r := range sorted {
+		lo := r.Lo
+		if len(res) > 0 {
+			last := res[len(res)-1]
+			if last >= r.Hi {
+				continue
+			}
+			if lo <= last {
+				lo = last + 1
+			}
+		}
+		if lo > r.Hi {
+			continue
+		}
+		if int64(len(res))+int64(r.Hi)-int64(lo)+1 > MaxClassSize {
+			panic(fmt.Sprintf("Class of more than %d characters", MaxClassSize))
+		}
+		// stop at Hi before incrementing, which may overflow
+		for char := lo; ; char++ {
+			res = append(res, char)
+			if char == r.Hi {
+				break
+			}
+		}
+	}
+	return res
+}
+
+// the largest bound allowed by Repeat, the same as in regular expressions
+const maxRepeat = 1 << 20
+
+// Repeat returns an NFA that matches between min and max words matched by
+// n, one after the other. If max is negative, there is no upper bound. It
+// panics if min is negative, if max is below min, or if either is over
+// 2^20, as regex.Parse rejects such repetitions.
+func Repeat(n NFA, min, max int) NFA {
+	if min < 0 || (max >= 0 && max < min) {
+		panic(fmt.Sprintf("Invalid repetition {%d,%d}", min, max))
+	}
+	if min > maxRepeat || max > maxRepeat {
+		panic(fmt.Sprintf("Repetition over %d", maxRepeat))
+	}
+	res := Empty()
+	for i := 0; i < min; i++ {
+		res = Concat(res, n)
+	}
+	if max < 0 {
+		return Concat(res, Star(n))
+	}
+	optional := Either(n, Empty())
+	for i := min; i < max; i++ {
+		res = Concat(res, optional)
+	}
 	return res
 }
 
@@ -37,6 +297,9 @@ func Copy(n NFA) NFA {
 func Concat(n1 NFA, n2 NFA) (n3 NFA) {
 	n1 = Copy(n1)
 	n2 = Copy(n2)
+	alphabet, ends := unionAlphabet(&n1, &n2)
+	n1.widen(alphabet, ends)
+	n2.widen(alphabet, ends)
 	n3 = New()
 	offset := n1.NumStates
 	n3.NumStates = n1.NumStates + n2.NumStates + 1
@@ -46,7 +309,11 @@ func Concat(n1 NFA, n2 NFA) (n3 NFA) {
 	for _, node := range n2.FinalStates {
 		n3.FinalStates = append(n3.FinalStates, node+offset)
 	}
+	for node, labels := range n2.Labels {
+		n3.Labels[node+offset] = labels
+	}
 	n3.Graph = n1.Graph
+	n3.Epsilon = n1.Epsilon
 	for node, _ := range n2.Graph {
 		for character, _ := range n2.Graph[node] {
 			for _, neighbour := range n2.Graph[node][character] {
@@ -61,16 +328,16 @@ func Concat(n1 NFA, n2 NFA) (n3 NFA) {
 			}
 		}
 	}
-	for _, node := range n1.FinalStates {
-		if _, ok := n3.Graph[node]; !ok {
-			n3.Graph[node] = make(map[rune][]int)
-		}
-		if _, ok := n3.Graph[node]['λ']; !ok {
-			n3.Graph[node]['λ'] = make([]int, 0, 1)
+	for node, neighbours := range n2.Epsilon {
+		for _, neighbour := range neighbours {
+			n3.Epsilon[node+offset] = append(n3.Epsilon[node+offset], neighbour+offset)
 		}
-		n3.Graph[node]['λ'] = append(n3.Graph[node]['λ'], n3.NumStates)
 	}
-	n3.Graph[n3.NumStates] = map[rune][]int{'λ': []int{n2.EntryState + offset}}
+	for _, node := range n1.FinalStates {
+		n3.Epsilon[node] = append(n3.Epsilon[node], n3.NumStates)
+	}
+	n3.Epsilon[n3.NumStates] = []int{n2.EntryState + offset}
+	n3.Alphabet, n3.Ends = alphabet, ends
 	return
 }
 
@@ -79,6 +346,9 @@ func Concat(n1 NFA, n2 NFA) (n3 NFA) {
 func Either(n1 NFA, n2 NFA) (n3 NFA) {
 	n1 = Copy(n1)
 	n2 = Copy(n2)
+	alphabet, ends := unionAlphabet(&n1, &n2)
+	n1.widen(alphabet, ends)
+	n2.widen(alphabet, ends)
 	n3 = New()
 	n3.NumStates = n1.NumStates + n2.NumStates + 1
 	n3.NumTransitions = n1.NumTransitions + n2.NumTransitions + 2
@@ -89,7 +359,12 @@ func Either(n1 NFA, n2 NFA) (n3 NFA) {
 	for _, node := range n2.FinalStates {
 		n3.FinalStates = append(n3.FinalStates, node+offset)
 	}
+	n3.Labels = n1.Labels
+	for node, labels := range n2.Labels {
+		n3.Labels[node+offset] = labels
+	}
 	n3.Graph = n1.Graph
+	n3.Epsilon = n1.Epsilon
 	for node, _ := range n2.Graph {
 		for character, _ := range n2.Graph[node] {
 			for _, neighbour := range n2.Graph[node][character] {
@@ -104,9 +379,13 @@ func Either(n1 NFA, n2 NFA) (n3 NFA) {
 			}
 		}
 	}
-	n3.Graph[n3.EntryState] = map[rune][]int{
-		'λ': []int{n1.EntryState, n2.EntryState + offset},
+	for node, neighbours := range n2.Epsilon {
+		for _, neighbour := range neighbours {
+			n3.Epsilon[node+offset] = append(n3.Epsilon[node+offset], neighbour+offset)
+		}
 	}
+	n3.Epsilon[n3.EntryState] = []int{n1.EntryState, n2.EntryState + offset}
+	n3.Alphabet, n3.Ends = alphabet, ends
 	return
 }
 
@@ -117,150 +396,342 @@ func Star(n1 NFA) (n2 NFA) {
 	n2 = New()
 	n2.NumStates = n1.NumStates + 1
 	n2.NumTransitions = n1.NumTransitions + len(n1.FinalStates) + 1
-	n2.EntryState = n1.EntryState
-	n2.FinalStates = []int{n1.EntryState}
+	// the loop goes through a new state, as the entry state of n1 may be
+	// reached again by reading some characters, which mustn't make them
+	// accepted
+	n2.EntryState = n2.NumStates
+	// the final states of n1 stay final, to keep their labels
+	n2.FinalStates = make([]int, len(n1.FinalStates), len(n1.FinalStates)+1)
+	copy(n2.FinalStates, n1.FinalStates)
+	n2.FinalStates = append(n2.FinalStates, n2.NumStates)
+	n2.Labels = n1.Labels
 	n2.Graph = n1.Graph
+	n2.Epsilon = n1.Epsilon
 	for _, state := range n1.FinalStates {
-		if _, ok := n2.Graph[state]; !ok {
-			n2.Graph[state] = make(map[rune][]int)
+		n2.Epsilon[state] = append(n2.Epsilon[state], n2.NumStates)
+	}
+	n2.Epsilon[n2.NumStates] = []int{n1.EntryState}
+	n2.Alphabet, n2.Ends = n1.Alphabet, n1.Ends
+	return
+}
+
+// Reverses an NFA. If the original NFA matches φ, the resulting NFA will
+// match φ read backwards. Every transition is turned around, and a new
+// entry state leads to the old final states through λ-transitions.
+func Reverse(n1 NFA) (n2 NFA) {
+	n2 = New()
+	n2.NumStates = n1.NumStates + 1
+	n2.NumTransitions = n1.NumTransitions + len(n1.FinalStates)
+	n2.EntryState = n2.NumStates
+	n2.FinalStates = []int{n1.EntryState}
+	for node, _ := range n1.Graph {
+		for character, neighbours := range n1.Graph[node] {
+			for _, neighbour := range neighbours {
+				if _, ok := n2.Graph[neighbour]; !ok {
+					n2.Graph[neighbour] = make(map[rune][]int)
+				}
+				n2.Graph[neighbour][character] = append(n2.Graph[neighbour][character], node)
+			}
 		}
-		if _, ok := n2.Graph[state]['λ']; !ok {
-			n2.Graph[state]['λ'] = make([]int, 0, 1)
+	}
+	for node, neighbours := range n1.Epsilon {
+		for _, neighbour := range neighbours {
+			n2.Epsilon[neighbour] = append(n2.Epsilon[neighbour], node)
 		}
-		n2.Graph[state]['λ'] = append(n2.Graph[state]['λ'], n2.NumStates)
 	}
-	n2.Graph[n2.NumStates] = map[rune][]int{'λ': []int{n1.EntryState}}
+	n2.Epsilon[n2.EntryState] = make([]int, len(n1.FinalStates))
+	copy(n2.Epsilon[n2.EntryState], n1.FinalStates)
+	n2.Alphabet, n2.Ends = copyAlphabet(n1.Alphabet), copyEnds(n1.Ends)
 	return
 }
 
-// Transforms an NFA into a DFA that accepts the same language; some
-// inaccessible states will be lost in the process
-func (original_nfa *NFA) ToDFA() dfa.DFA {
-	nfa := Copy(*original_nfa)
-	n := &nfa
-	// first follow along λ-transitions to make them obsolete
+// ReverseDFA returns an NFA matching the words accepted by the DFA, read
+// backwards
+func ReverseDFA(d dfa.DFA) NFA {
+	return Reverse(FromDFA(d))
+}
+
+// Transforms an NFA into a DFA that accepts the same language, using the
+// subset construction. Only the sets of NFA states that can actually be
+// reached are turned into DFA states, so NFAs with many states are fine as
+// long as the resulting DFA stays small.
+func (n *NFA) ToDFA() dfa.DFA {
+	res, _ := n.ToDFAContext(context.Background(), dfa.Limits{})
+	return res
+}
+
+// rough number of bytes used by ToDFA for every DFA state, on top of the NFA
+// states it is made of, and for every transition
+const (
+	stateCost      = 96
+	transitionCost = 48
+)
+
+// ToDFAContext works like ToDFA, but gives up as soon as the context is done
+// or the DFA goes over the given limits, returning either the context's
+// error, dfa.ErrTooManyStates or dfa.ErrTooMuchMemory.
+func (n *NFA) ToDFAContext(ctx context.Context, limits dfa.Limits) (dfa.DFA, error) {
+	if limits.MaxNFAStates > 0 && n.NumStates > limits.MaxNFAStates {
+		return dfa.New(), fmt.Errorf("%w: the NFA has %d states, at most %d are allowed",
+			dfa.ErrTooManyStates, n.NumStates, limits.MaxNFAStates)
+	}
+	res := dfa.New()
+	res.Alphabet, res.Ends = copyAlphabet(n.Alphabet), copyEnds(n.Ends)
+	memory := 0
 	is_final := make([]bool, n.NumStates+1)
 	for _, node := range n.FinalStates {
 		is_final[node] = true
 	}
-	for i := 1; i <= n.NumStates; i++ {
-		q := queue.New(n.NumStates)
-		added := make([]bool, n.NumStates+1)
-		for _, node := range n.Graph[i]['λ'] {
-			q.Push(node)
-		}
-		for !q.Empty() {
-			node, _ := q.Pop()
-			if is_final[node] && !is_final[i] {
-				is_final[i] = true
-				n.FinalStates = append(n.FinalStates, i)
-			}
-			for character, neighbours := range n.Graph[node] {
-				for _, neighbour := range neighbours {
-					if character == 'λ' && !added[neighbour] {
-						q.Push(neighbour)
-						added[neighbour] = true
-					}
-					n.Graph[i][character] = append(n.Graph[i][character], neighbour)
-					n.NumTransitions++
-				}
-			}
+
+	// memory is reserved before it is used, so that the limits are never
+	// exceeded, even by the successors of a single state
+	reserve := func(cost int) error {
+		if limits.MaxMemory > 0 && memory+cost > limits.MaxMemory {
+			return fmt.Errorf("%w: the DFA needs more than %d bytes",
+				dfa.ErrTooMuchMemory, limits.MaxMemory)
 		}
+		memory += cost
+		return nil
 	}
 
-	// then remove λ-transitions
-	for i := 1; i <= n.NumStates; i++ {
-		_, ok := n.Graph[i]['λ']
-		if ok {
-			n.NumTransitions -= len(n.Graph[i]['λ'])
-			delete(n.Graph[i], 'λ')
+	// every DFA state is a set of NFA states closed under λ-transitions,
+	// kept sorted so that it can be looked up by its key
+	closure := stateset.New(n.NumStates + 1)
+	stack := make([]int, 0, n.NumStates+1)
+	sets := make([][]int, 0)
+	ids := make(map[string]int)
+	q := queue.New(1)
+	add := func(set []int) (int, error) {
+		key := setKey(set)
+		if id, ok := ids[key]; ok {
+			return id, nil
+		}
+		if limits.MaxDFAStates > 0 && len(sets) >= limits.MaxDFAStates {
+			return 0, fmt.Errorf("%w: the DFA needs more than %d states",
+				dfa.ErrTooManyStates, limits.MaxDFAStates)
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if err := reserve(stateCost + 8*len(set) + len(key)); err != nil {
+			return 0, err
+		}
+		sets = append(sets, set)
+		id := len(sets)
+		ids[key] = id
+		for _, node := range set {
+			if is_final[node] {
+				res.FinalStates = append(res.FinalStates, id)
+				break
+			}
+		}
+		if labels := n.labelsOf(set); len(labels) > 0 {
+			res.Labels[id] = labels
 		}
+		q.Push(id)
+		return id, nil
 	}
 
-	// then turn that into a DFA
-	visited := make([]bool, 1<<uint(n.NumStates+1))
-	dfa := make(map[int]map[rune]int)
-	q := queue.New(1 << uint(n.NumStates))
-	q.Push(1 << uint(n.EntryState-1))
-	final_states := make([]int, 0)
+	closure.Clear()
+	n.addClosure(closure, n.EntryState, stack)
+	var err error
+	if res.EntryState, err = add(sortedElements(closure)); err != nil {
+		return dfa.New(), err
+	}
 	for !q.Empty() {
-		node_code, _ := q.Pop()
-		is_final_node := false
-		for node := 1; node < (1 << uint(n.NumStates)); node++ {
-			if 1<<uint(node-1) > node_code {
-				break
+		id, _ := q.Pop()
+		neighbours := make(map[rune][]int)
+		for _, node := range sets[id-1] {
+			for character, targets := range n.Graph[node] {
+				neighbours[character] = append(neighbours[character], targets...)
 			}
-			if ((1 << uint(node-1)) & node_code) == 0 {
-				continue
+		}
+		if len(neighbours) == 0 {
+			continue
+		}
+		res.Graph[id] = make(map[rune]int)
+		for character, targets := range neighbours {
+			closure.Clear()
+			for _, node := range targets {
+				n.addClosure(closure, node, stack)
 			}
-			if is_final[node] {
-				is_final_node = true
+			if err := reserve(transitionCost); err != nil {
+				return dfa.New(), err
 			}
-			if _, ok := dfa[node_code]; !ok {
-				dfa[node_code] = make(map[rune]int)
+			next, err := add(sortedElements(closure))
+			if err != nil {
+				return dfa.New(), err
 			}
-			for character, neighbours := range n.Graph[node] {
-				for _, neighbour := range neighbours {
-					dfa[node_code][character] = dfa[node_code][character] | (1 << uint(neighbour-1))
-				}
+			res.Graph[id][character] = next
+			res.NumTransitions++
+		}
+	}
+	res.NumStates = len(sets)
+	return res, nil
+}
+
+// labelsOf returns, in increasing order, the labels of the given states
+func (n *NFA) labelsOf(states []int) []int {
+	if len(n.Labels) == 0 {
+		return nil
+	}
+	seen := make(map[int]bool)
+	res := make([]int, 0)
+	for _, node := range states {
+		for _, label := range n.Labels[node] {
+			if !seen[label] {
+				seen[label] = true
+				res = append(res, label)
 			}
-			for _, neighbour := range dfa[node_code] {
-				if !visited[neighbour] {
-					q.Push(neighbour)
-					visited[neighbour] = true
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+// sortedElements returns a sorted copy of the states in the set
+func sortedElements(set *stateset.Set) []int {
+	res := make([]int, set.Len())
+	copy(res, set.Elements())
+	sort.Ints(res)
+	return res
+}
+
+// setKey encodes a sorted set of states so that it can be used as a map key
+func setKey(set []int) string {
+	key := make([]byte, 0, 4*len(set))
+	for _, node := range set {
+		key = strconv.AppendInt(key, int64(node), 10)
+		key = append(key, ',')
+	}
+	return string(key)
+}
+
+// ToRegex returns a regular expression, in the syntax understood by
+// regex.Parse, that matches the words accepted by the NFA. It works by
+// eliminating the states of the NFA one by one, without determinizing it
+// first. The transitions on Other are written as for a DFA. ok is false if
+// the NFA doesn't accept any word, as there is no expression for that.
+func (n *NFA) ToRegex() (re string, ok bool) {
+	g := gnfa.New(n.NumStates)
+	except := make([]gnfa.Range, 0, len(n.Alphabet))
+	for _, r := range dfa.Ranges(n.Alphabet, n.Ends) {
+		except = append(except, gnfa.Range(r))
+	}
+	other := len(n.Alphabet) > 0 && n.Alphabet[0] == Other
+	for node := 0; node <= n.NumStates; node++ {
+		characters := make([]rune, 0, len(n.Graph[node]))
+		for character, _ := range n.Graph[node] {
+			characters = append(characters, character)
+		}
+		sort.Slice(characters, func(i, j int) bool { return characters[i] < characters[j] })
+		for _, character := range characters {
+			for _, neighbour := range n.Graph[node][character] {
+				if hi, ok := n.Ends[character]; ok {
+					g.AddRange(node, neighbour, character, hi)
+				} else if character != Other {
+					g.AddTransition(node, neighbour, character)
+				} else if other {
+					g.AddExcept(node, neighbour, except)
 				}
 			}
 		}
-		if is_final_node {
-			final_states = append(final_states, node_code)
+		for _, neighbour := range n.Epsilon[node] {
+			g.AddEpsilon(node, neighbour)
 		}
 	}
+	return g.ToRegex(n.EntryState, n.FinalStates)
+}
 
-	// encode the newly created DFA into our standard form
-	for node, _ := range n.Graph {
-		delete(n.Graph, node)
-	}
-	nr := n.NumStates
-	n.NumStates = 0
-	n.NumTransitions = 0
-	n.EntryState = 1 << uint(n.EntryState-1)
+// symbol returns the character the NFA has transitions on for the given
+// one: the symbol of the alphabet whose range holds it, or Other if there is
+// none and the alphabet has Other, or else the character itself
+func (n *NFA) symbol(char rune) rune {
+	return dfa.Symbol(n.Alphabet, n.Ends, char)
+}
 
-	mapping := make(map[int]int)
-	current_node := 0
-	for i := 1; i < (1 << uint(nr)); i++ {
-		if _, ok := dfa[i]; !ok || len(dfa[i]) == 0 {
-			continue
-		}
+func copyAlphabet(alphabet []rune) []rune {
+	if alphabet == nil {
+		return nil
+	}
+	res := make([]rune, len(alphabet))
+	copy(res, alphabet)
+	return res
+}
 
-		if _, ok := mapping[i]; !ok {
-			current_node++
-			mapping[i] = current_node
-		}
-		n.Graph[mapping[i]] = make(map[rune][]int)
-		n.NumTransitions += len(dfa[i])
-		for character, node := range dfa[i] {
-			if _, ok := mapping[node]; !ok {
-				current_node++
-				mapping[node] = current_node
+// symbols returns the alphabet of the NFA, in increasing order: Alphabet if
+// it's set, or else the characters on the transitions
+func (n *NFA) symbols() []rune {
+	if n.Alphabet != nil {
+		return n.Alphabet
+	}
+	seen := make(map[rune]bool)
+	res := make([]rune, 0)
+	for _, edges := range n.Graph {
+		for character, _ := range edges {
+			if !seen[character] {
+				seen[character] = true
+				res = append(res, character)
 			}
-			n.Graph[mapping[i]][character] = []int{mapping[node]}
 		}
 	}
-	if _, ok := mapping[n.EntryState]; ok {
-		n.EntryState = mapping[n.EntryState]
-	} else {
-		current_node++
-		mapping[n.EntryState] = current_node
-		n.EntryState = current_node
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func copyEnds(ends map[rune]rune) map[rune]rune {
+	if ends == nil {
+		return nil
+	}
+	res := make(map[rune]rune, len(ends))
+	for symbol, hi := range ends {
+		res[symbol] = hi
+	}
+	return res
+}
+
+// refine returns the alphabet made of the characters of the alphabets of
+// both NFAs, split so that each of its symbols stands for whole symbols of
+// both. It has Other if any of them has it, or if other is set.
+func refine(a, b *NFA, other bool) ([]rune, map[rune]rune) {
+	ranges := make([]dfa.Range, 0)
+	for _, n := range []*NFA{a, b} {
+		symbols := n.symbols()
+		other = other || (len(symbols) > 0 && symbols[0] == Other)
+		ranges = append(ranges, dfa.Ranges(symbols, n.Ends)...)
+	}
+	return dfa.Refine(ranges, other)
+}
+
+// unionAlphabet returns the alphabet that both NFAs can be widened to, or
+// nil if both are implicit
+func unionAlphabet(a, b *NFA) ([]rune, map[rune]rune) {
+	if a.Alphabet == nil && b.Alphabet == nil {
+		return nil, nil
 	}
-	n.NumStates = current_node
-	n.FinalStates = make([]int, 0)
+	return refine(a, b, false)
+}
 
-	for _, node := range final_states {
-		if _, ok := mapping[node]; ok {
-			n.FinalStates = append(n.FinalStates, mapping[node])
+// widen gives the NFA a larger alphabet, whose symbols each stand for
+// characters of a single symbol of the NFA's. The new symbols used to be
+// read through the one holding them, or through Other, so its transitions
+// are copied onto them and the NFA still accepts the same words.
+func (n *NFA) widen(alphabet []rune, ends map[rune]rune) {
+	if alphabet == nil {
+		return
+	}
+	for _, edges := range n.Graph {
+		for _, char := range alphabet {
+			symbol := n.symbol(char)
+			if symbol == char {
+				continue
+			}
+			if neighbours, ok := edges[symbol]; ok {
+				edges[char] = make([]int, len(neighbours))
+				copy(edges[char], neighbours)
+				n.NumTransitions += len(neighbours)
+			}
 		}
 	}
-
-	return n.DFA
+	n.Alphabet, n.Ends = alphabet, ends
 }
@@ -1,8 +1,15 @@
 package nfa
 
 import (
+	"context"
+	"dfa"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 var (
@@ -86,3 +93,823 @@ func TestStar(t *testing.T) {
 
 	Star(nfa)
 }
+
+func TestMatch(t *testing.T) {
+	nfa := New()
+	nfa.Process(strings.NewReader(simple_nfa))
+	dfa := nfa.ToDFA()
+	for _, str := range []string{"", "a", "b", "ab", "ba", "abbbbbb", "ababba", "babababa", "bbb", "c"} {
+		if nfa.Match(str) != dfa.Check(str) {
+			t.Errorf("Match and ToDFA disagree at: %q", str)
+		}
+	}
+}
+
+func TestMatchLarge(t *testing.T) {
+	// (a|b)*a(a|b)(a|b)...(a|b) has far too many states to be turned into a
+	// DFA, but it can still be simulated
+	nfa := lastCharacters(40)
+	if !nfa.Match("bab" + "a" + strings.Repeat("b", 40)) {
+		t.Errorf("Match should succeed")
+	}
+	if nfa.Match(strings.Repeat("b", 41)) {
+		t.Errorf("Match should fail")
+	}
+	if nfa.Match("a" + strings.Repeat("b", 39)) {
+		t.Errorf("Match should fail on a word that is too short")
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	nfa := lastCharacters(40)
+	word := strings.Repeat("ab", 500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nfa.Match(word)
+	}
+}
+
+// lastCharacters returns an NFA for (a|b)*a(a|b)(a|b)... with k copies of
+// (a|b) at the end, whose DFA needs 2^(k+1) states
+func lastCharacters(k int) NFA {
+	ab := Either(Rune('a'), Rune('b'))
+	nfa := Concat(Star(ab), Rune('a'))
+	for i := 0; i < k; i++ {
+		nfa = Concat(nfa, ab)
+	}
+	return nfa
+}
+
+func TestToDFALarge(t *testing.T) {
+	nfa := lastCharacters(10)
+	if nfa.NumStates < 64 {
+		t.Fatalf("The NFA should have more than 64 states, it has %d", nfa.NumStates)
+	}
+	dfa := nfa.ToDFA()
+	if dfa.NumStates < 1<<11 {
+		t.Errorf("Expected at least %d DFA states, got %d", 1<<11, dfa.NumStates)
+	}
+	words := []string{
+		"a" + strings.Repeat("b", 10),
+		"bbbabbbbbbbbbb",
+		"aaaaaaaaaaaa",
+		strings.Repeat("b", 11),
+		"ab" + strings.Repeat("b", 10),
+		"abababababab",
+		"aabababababab",
+	}
+	for _, word := range words {
+		if dfa.Check(word) != nfa.Match(word) {
+			t.Errorf("ToDFA and Match disagree at: %s", word)
+		}
+	}
+}
+
+func BenchmarkNFAToDFALarge(b *testing.B) {
+	nfa := lastCharacters(8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nfa.ToDFA()
+	}
+}
+
+func TestLazyDFA(t *testing.T) {
+	nfa := lastCharacters(12)
+	words := []string{
+		"",
+		"a" + strings.Repeat("b", 12),
+		strings.Repeat("ab", 40),
+		strings.Repeat("ba", 40) + "a",
+		strings.Repeat("aab", 30) + "abbbabbbabbb",
+		strings.Repeat("b", 13),
+		"abc" + strings.Repeat("b", 12),
+	}
+	random := rand.New(rand.NewSource(42))
+	for i := 0; i < 5; i++ {
+		word := make([]byte, 300)
+		for j := range word {
+			word[j] = "ab"[random.Intn(2)]
+		}
+		words = append(words, string(word))
+	}
+
+	lazy := NewLazyDFA(&nfa, 1<<20)
+	for _, word := range words {
+		if lazy.Check(word) != nfa.Match(word) {
+			t.Errorf("LazyDFA and Match disagree at: %s", word)
+		}
+	}
+	if lazy.Flushes != 0 || lazy.Fallbacks != 0 {
+		t.Errorf("A large cache should not be flushed: %d flushes", lazy.Flushes)
+	}
+	states := lazy.CachedStates()
+	for _, word := range words {
+		lazy.Check(word)
+	}
+	if lazy.CachedStates() != states {
+		t.Errorf("Checking the same words again should only use cached states")
+	}
+
+	small := NewLazyDFA(&nfa, 2000)
+	for _, word := range words {
+		if small.Check(word) != nfa.Match(word) {
+			t.Errorf("LazyDFA with a small cache and Match disagree at: %s", word)
+		}
+	}
+	if small.Flushes == 0 || small.Fallbacks == 0 {
+		t.Errorf("A small cache should be flushed, then given up on")
+	}
+	if small.used > small.budget {
+		t.Errorf("The cache uses %d bytes, over its budget of %d", small.used, small.budget)
+	}
+
+	// the transition found right after a flush is cached too, along with
+	// the state it comes from
+	for budget := 400; budget <= 2000; budget += 100 {
+		lazy := NewLazyDFA(&nfa, budget)
+		for _, word := range words[1:] {
+			fallbacks := lazy.Fallbacks
+			lazy.Check(word)
+			transitions := 0
+			for _, next := range lazy.next {
+				transitions += len(next)
+			}
+			if lazy.Fallbacks == fallbacks && transitions == 0 {
+				t.Errorf("Budget %d: the last transition of %s wasn't cached", budget, word)
+			}
+			if lazy.used > lazy.budget {
+				t.Errorf("The cache uses %d bytes, over its budget of %d", lazy.used, lazy.budget)
+			}
+		}
+	}
+
+	none := NewLazyDFA(&nfa, 0)
+	for _, word := range words {
+		if none.Check(word) != nfa.Match(word) {
+			t.Errorf("LazyDFA without a cache and Match disagree at: %s", word)
+		}
+	}
+}
+
+func BenchmarkLazyDFA(b *testing.B) {
+	nfa := lastCharacters(20)
+	lazy := NewLazyDFA(&nfa, 1<<20)
+	word := strings.Repeat("ab", 500)
+	for i := 0; i < b.N; i++ {
+		lazy.Check(word)
+	}
+}
+
+func TestToDFAContext(t *testing.T) {
+	nfa := lastCharacters(10)
+	if _, err := nfa.ToDFAContext(context.Background(), dfa.Limits{MaxDFAStates: 100}); !errors.Is(err, dfa.ErrTooManyStates) {
+		t.Errorf("Expected ErrTooManyStates, got %v", err)
+	}
+	if _, err := nfa.ToDFAContext(context.Background(), dfa.Limits{MaxNFAStates: 10}); !errors.Is(err, dfa.ErrTooManyStates) {
+		t.Errorf("Expected ErrTooManyStates, got %v", err)
+	}
+	if _, err := nfa.ToDFAContext(context.Background(), dfa.Limits{MaxMemory: 10000}); !errors.Is(err, dfa.ErrTooMuchMemory) {
+		t.Errorf("Expected ErrTooMuchMemory, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := nfa.ToDFAContext(ctx, dfa.Limits{}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	res, err := nfa.ToDFAContext(context.Background(), dfa.Limits{MaxDFAStates: 1 << 12})
+	if err != nil {
+		t.Fatalf("ToDFAContext failed within the limits: %v", err)
+	}
+	if !res.Check("a" + strings.Repeat("b", 10)) {
+		t.Errorf("Wrong DFA")
+	}
+
+	// a single state with 1000 successors, which mustn't go past the limit
+	wide := New()
+	wide.NumStates = 1001
+	wide.EntryState = 1
+	wide.Graph[1] = make(map[rune][]int)
+	for i := 0; i < 1000; i++ {
+		wide.Graph[1][rune('a'+i)] = []int{i + 2}
+	}
+	wide.FinalStates = []int{2}
+	if _, err := wide.ToDFAContext(context.Background(), dfa.Limits{MaxDFAStates: 1000}); !errors.Is(err, dfa.ErrTooManyStates) {
+		t.Errorf("Expected ErrTooManyStates, got %v", err)
+	}
+	if _, err := wide.ToDFAContext(context.Background(), dfa.Limits{MaxDFAStates: 1001}); err != nil {
+		t.Errorf("ToDFAContext failed within the limits: %v", err)
+	}
+}
+
+func TestNFAProcess(t *testing.T) {
+	nfa := New()
+	nfa.Process(strings.NewReader(simple_nfa))
+	if len(nfa.Epsilon[1]) != 1 || nfa.Epsilon[1][0] != 2 || len(nfa.Epsilon[2]) != 1 {
+		t.Errorf("λ-transitions not read as such: %v", nfa.Epsilon)
+	}
+	if _, ok := nfa.Graph[1]['λ']; ok {
+		t.Errorf("λ-transitions read as transitions on λ")
+	}
+	if len(nfa.Graph[1]['a']) != 4 {
+		t.Errorf("Wrong number of transitions on a: %v", nfa.Graph[1]['a'])
+	}
+
+	// state 0 is the null state, which isn't printed even if it has
+	// transitions
+	nfa.Graph[0] = map[rune][]int{'a': []int{1}}
+	var b strings.Builder
+	nfa.Print(&b)
+	if strings.Contains(b.String(), "\n0 ") {
+		t.Errorf("The null state was printed:\n%s", b.String())
+	}
+	delete(nfa.Graph, 0)
+	printed := New()
+	printed.Process(strings.NewReader(b.String()))
+	for _, str := range []string{"", "a", "b", "ab", "ba", "abbbbbb", "ababba", "babababa"} {
+		if nfa.Match(str) != printed.Match(str) {
+			t.Errorf("Printed NFA differs at: %q", str)
+		}
+	}
+}
+
+func TestFromDFA(t *testing.T) {
+	nfa := New()
+	nfa.Process(strings.NewReader(simple_nfa))
+	d := nfa.ToDFA()
+	back := FromDFA(d)
+	if back.NumStates != d.NumStates || back.NumTransitions != d.NumTransitions {
+		t.Errorf("FromDFA changed the size of the automaton")
+	}
+	for _, str := range []string{"", "a", "b", "ab", "ba", "abbbbbb", "ababba", "babababa"} {
+		if back.Match(str) != d.Check(str) {
+			t.Errorf("FromDFA changed the language at: %q", str)
+		}
+	}
+}
+
+func TestLambdaCharacter(t *testing.T) {
+	// λ is an ordinary character, λ-transitions are kept apart
+	nfa := Concat(Star(Rune('λ')), Rune('a'))
+	for str, res := range map[string]bool{"a": true, "λλa": true, "λ": false, "": false} {
+		if nfa.Match(str) != res {
+			t.Errorf("Match fails at: %q", str)
+		}
+		dfa := nfa.ToDFA()
+		if dfa.Check(str) != res {
+			t.Errorf("ToDFA fails at: %q", str)
+		}
+	}
+}
+
+func TestBuilders(t *testing.T) {
+	type Test struct {
+		Name  string
+		NFA   NFA
+		Words map[string]bool
+	}
+	digits := Class([]Range{{'0', '9'}})
+	tests := []Test{
+		Test{"Empty", Empty(), map[string]bool{"": true, "a": false}},
+		Test{"Never", Never(), map[string]bool{"": false, "a": false}},
+		Test{"Rune", Rune('x'), map[string]bool{"x": true, "": false, "xx": false, "y": false}},
+		Test{"Literal", Literal("héllo"), map[string]bool{"héllo": true, "hello": false, "héll": false, "": false}},
+		Test{"Literal empty", Literal(""), map[string]bool{"": true, "a": false}},
+		Test{"Class", Class([]Range{{'a', 'c'}, {'x', 'x'}, {'b', 'd'}}), map[string]bool{
+			"a": true, "c": true, "d": true, "x": true, "e": false, "w": false, "ab": false, "": false,
+		}},
+		Test{"Repeat", Repeat(digits, 2, 4), map[string]bool{
+			"1": false, "12": true, "123": true, "1234": true, "12345": false, "1a": false,
+		}},
+		Test{"Repeat exactly", Repeat(Literal("ab"), 2, 2), map[string]bool{
+			"ab": false, "abab": true, "ababab": false,
+		}},
+		Test{"Repeat unbounded", Repeat(digits, 1, -1), map[string]bool{
+			"": false, "1": true, "1234567890": true, "12a": false,
+		}},
+		Test{"Repeat nothing", Repeat(digits, 0, 0), map[string]bool{"": true, "1": false}},
+		// the entry state of a*b is reached again after reading a
+		Test{"Star re-entered", Star(Concat(Star(Rune('a')), Rune('b'))), map[string]bool{
+			"": true, "a": false, "ab": true, "abaab": true, "aba": false,
+		}},
+		Test{"Repeat re-entered", Repeat(Concat(Star(Rune('a')), Rune('b')), 0, -1), map[string]bool{
+			"": true, "a": false, "ab": true, "abaab": true, "aba": false,
+		}},
+	}
+	for _, test := range tests {
+		dfa := test.NFA.ToDFA()
+		for word, res := range test.Words {
+			if test.NFA.Match(word) != res || dfa.Check(word) != res {
+				t.Errorf("%s fails at: %q", test.Name, word)
+			}
+		}
+	}
+	if class := Class([]Range{{'a', 'c'}, {'b', 'd'}}); class.NumTransitions != 4 {
+		t.Errorf("Class has %d transitions, expected 4", class.NumTransitions)
+	}
+	// the loop mustn't overflow past the largest rune
+	if class := Class([]Range{{math.MaxInt32 - 1, math.MaxInt32}, {'a', 'a'}}); class.NumTransitions != 3 {
+		t.Errorf("Class has %d transitions, expected 3", class.NumTransitions)
+	}
+	if class := Class([]Range{{'b', 'a'}}); class.NumTransitions != 0 {
+		t.Errorf("An inverted range should be empty, got %d transitions", class.NumTransitions)
+	}
+
+	panics := map[string]func(){
+		"Class of every character":   func() { Class([]Range{{0, utf8.MaxRune}}) },
+		"Builder.Class too large":    func() { NewBuilder().Class([]Range{{0, MaxClassSize}}) },
+		"Repeat with a negative min": func() { Repeat(digits, -1, 2) },
+		"Repeat with max below min":  func() { Repeat(digits, 3, 2) },
+		"Repeat too large":           func() { Repeat(digits, 0, 1<<21) },
+	}
+	for name, f := range panics {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s should panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	// (ab|c)*(d|()) built both ways
+	b := NewBuilder()
+	f := b.Concat(
+		b.Star(b.Either(b.Literal("ab"), b.Rune('c'))),
+		b.Either(b.Class([]Range{{'d', 'd'}}), b.Empty()))
+	built := b.Build(f)
+	combined := Concat(Star(Either(Literal("ab"), Rune('c'))), Either(Rune('d'), Empty()))
+	words := []string{"", "ab", "c", "d", "abcd", "cabab", "abcabd", "a", "abd", "dd", "acd", "abdc"}
+	for _, word := range words {
+		if built.Match(word) != combined.Match(word) {
+			t.Errorf("Builder and combinators disagree at: %q", word)
+		}
+	}
+
+	b = NewBuilder()
+	never := b.Build(b.Either(b.Never(), b.Star(b.Never())))
+	if !never.Match("") || never.Match("a") {
+		t.Errorf("Never fragment fails")
+	}
+}
+
+const longConcat = 2000
+
+func BenchmarkConcat(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		res := Rune('a')
+		for j := 0; j < longConcat; j++ {
+			res = Concat(res, Rune('a'))
+		}
+	}
+}
+
+func BenchmarkBuilderConcat(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		builder := NewBuilder()
+		res := builder.Rune('a')
+		for j := 0; j < longConcat; j++ {
+			res = builder.Concat(res, builder.Rune('a'))
+		}
+		builder.Build(res)
+	}
+}
+
+func TestEpsilonClosure(t *testing.T) {
+	nfa := New()
+	nfa.Process(strings.NewReader(simple_nfa))
+	tests := map[int][]int{1: []int{1, 2, 3}, 2: []int{2, 3}, 3: []int{3}, 4: []int{4}}
+	for state, expected := range tests {
+		closure := nfa.EpsilonClosure([]int{state})
+		if fmt.Sprint(closure) != fmt.Sprint(expected) {
+			t.Errorf("Closure of %d is %v, expected %v", state, closure, expected)
+		}
+	}
+	if closure := nfa.EpsilonClosure([]int{4, 2}); fmt.Sprint(closure) != "[2 3 4]" {
+		t.Errorf("Closure of [4 2] is %v", closure)
+	}
+}
+
+// words returns every word up to the given length over the alphabet
+func words(alphabet string, length int) []string {
+	res := []string{""}
+	last := []string{""}
+	for i := 0; i < length; i++ {
+		next := make([]string, 0)
+		for _, word := range last {
+			for _, char := range alphabet {
+				next = append(next, word+string(char))
+			}
+		}
+		res = append(res, next...)
+		last = next
+	}
+	return res
+}
+
+func TestRemoveEpsilons(t *testing.T) {
+	simple := New()
+	simple.Process(strings.NewReader(simple_nfa))
+	automata := []NFA{
+		simple,
+		Concat(Star(Either(Literal("ab"), Rune('c'))), Either(Rune('a'), Empty())),
+		Star(Star(Either(Empty(), Literal("ba")))),
+		Repeat(Either(Rune('a'), Rune('b')), 2, 3),
+	}
+	for i, nfa := range automata {
+		res := nfa.RemoveEpsilons()
+		if len(res.Epsilon) != 0 {
+			t.Errorf("Automaton %d still has λ-transitions", i)
+		}
+		if res.NumStates != nfa.NumStates {
+			t.Errorf("Automaton %d has %d states instead of %d", i, res.NumStates, nfa.NumStates)
+		}
+		for _, word := range words("abc", 6) {
+			if res.Match(word) != nfa.Match(word) {
+				t.Errorf("Automaton %d changed its language at: %q", i, word)
+			}
+		}
+	}
+}
+
+func reversed(word string) string {
+	runes := []rune(word)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func TestReverse(t *testing.T) {
+	simple := New()
+	simple.Process(strings.NewReader(simple_nfa))
+	automata := []NFA{
+		simple,
+		Concat(Star(Either(Literal("ab"), Rune('c'))), Either(Rune('a'), Empty())),
+		Literal("abcab"),
+		lastCharacters(3),
+	}
+	for i, nfa := range automata {
+		res := Reverse(nfa)
+		if res.NumStates != nfa.NumStates+1 {
+			t.Errorf("Automaton %d has %d states, expected %d", i, res.NumStates, nfa.NumStates+1)
+		}
+		fromDFA := ReverseDFA(nfa.ToDFA())
+		twice := Reverse(res)
+		for _, word := range words("abc", 6) {
+			if res.Match(word) != nfa.Match(reversed(word)) {
+				t.Errorf("Reverse of automaton %d fails at: %q", i, word)
+			}
+			if fromDFA.Match(word) != nfa.Match(reversed(word)) {
+				t.Errorf("ReverseDFA of automaton %d fails at: %q", i, word)
+			}
+			if twice.Match(word) != nfa.Match(word) {
+				t.Errorf("Reversing automaton %d twice fails at: %q", i, word)
+			}
+		}
+	}
+}
+
+var text_nfa = `# (a|b)*c with an extra entry state
+states 4
+entry 1 4
+final 3
+1 2 eps
+2 1 'a'
+2 1 'b'
+2 3 'c'
+4 3 ' '
+4 4 '\n'
+4 4 'λ'
+`
+
+func TestRead(t *testing.T) {
+	nfa, err := Read(strings.NewReader(text_nfa))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if nfa.NumStates != 5 || nfa.NumTransitions != 9 || nfa.EntryState != 5 {
+		t.Errorf("Wrong NFA: %d states, %d transitions, entry %d",
+			nfa.NumStates, nfa.NumTransitions, nfa.EntryState)
+	}
+	tests := map[string]bool{"c": true, "abac": true, " ": true, "\nλ\n ": true, "a": false, "": false, "λ": false}
+	for word, res := range tests {
+		if nfa.Match(word) != res {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+
+	var b strings.Builder
+	if err := nfa.Write(&b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	written := b.String()
+	again, err := Read(strings.NewReader(written))
+	if err != nil {
+		t.Fatalf("Reading back failed: %v\n%s", err, written)
+	}
+	b.Reset()
+	again.Write(&b)
+	if b.String() != written {
+		t.Errorf("Writing, reading and writing again changed the text:\n%s\n%s", written, b.String())
+	}
+	for word, res := range tests {
+		if again.Match(word) != res {
+			t.Errorf("Wrong answer after reading back at: %q", word)
+		}
+	}
+
+	invalid := []string{
+		"states 2\nfinal 1\n",
+		"states 2\nentry 3\n",
+		"states 2\nentry 1\n1 2 a\n",
+		"states 2\nentry 1\n1 2 'ab'\n",
+		"states 2\nentry 1\n1 'a'\n",
+		"states x\n",
+	}
+	for _, text := range invalid {
+		if _, err := Read(strings.NewReader(text)); err == nil {
+			t.Errorf("Reading should fail: %q", text)
+		}
+	}
+}
+
+func TestWriteRegex(t *testing.T) {
+	// the λ-NFA of a pattern can be stored and loaded back
+	b := NewBuilder()
+	nfa := b.Build(b.Star(b.Either(b.Literal("ab"), b.Rune('c'))))
+	var text strings.Builder
+	nfa.Write(&text)
+	loaded, err := Read(strings.NewReader(text.String()))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(loaded.Epsilon) == 0 || loaded.NumTransitions != nfa.NumTransitions {
+		t.Errorf("λ-transitions lost")
+	}
+	for _, word := range words("abc", 5) {
+		if loaded.Match(word) != nfa.Match(word) {
+			t.Errorf("Loaded NFA differs at: %q", word)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	b := NewBuilder()
+	fragments := []Fragment{
+		b.Star(b.Rune('a')),
+		b.Literal("ab"),
+		b.Concat(b.Rune('a'), b.Star(b.Either(b.Rune('a'), b.Rune('b')))),
+	}
+	nfa := b.BuildLabelled(fragments)
+	dfa := nfa.ToDFA()
+	tests := map[string]string{
+		"":    "[0]",
+		"a":   "[0 2]",
+		"aa":  "[0 2]",
+		"ab":  "[1 2]",
+		"abb": "[2]",
+		"b":   "[]",
+	}
+	for word, expected := range tests {
+		state := dfa.EntryState
+		for _, char := range word {
+			state = dfa.Graph[state][char]
+		}
+		if labels := fmt.Sprint(dfa.Labels[state]); labels != expected {
+			t.Errorf("Wrong labels for %q: %s, expected %s", word, labels, expected)
+		}
+		if dfa.Check(word) != (expected != "[]") {
+			t.Errorf("Wrong answer for %q", word)
+		}
+	}
+
+	// labels go through Either and RemoveEpsilons
+	either := Either(nfa, Rune('c'))
+	removed := either.RemoveEpsilons()
+	d := removed.ToDFA()
+	if labels := d.Labels[d.Graph[d.EntryState]['a']]; fmt.Sprint(labels) != "[0 2]" {
+		t.Errorf("Wrong labels after Either and RemoveEpsilons: %v", labels)
+	}
+
+	// and through Star
+	b = NewBuilder()
+	star := Star(b.BuildLabelled([]Fragment{b.Literal("ab"), b.Rune('c')}))
+	d = star.ToDFA()
+	for word, expected := range map[string]string{"": "[]", "ab": "[0]", "abc": "[1]", "cab": "[0]", "cc": "[1]"} {
+		state := d.EntryState
+		for _, char := range word {
+			state = d.Graph[state][char]
+		}
+		if labels := fmt.Sprint(d.Labels[state]); labels != expected || !d.Check(word) {
+			t.Errorf("Wrong labels after Star for %q: %s, expected %s", word, labels, expected)
+		}
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	ab := func() NFA { return Either(Rune('a'), Rune('b')) }
+	type Test struct {
+		A, B     NFA
+		Included bool
+	}
+	tests := []Test{
+		// a*b ⊆ (a|b)*b
+		Test{Concat(Star(Rune('a')), Rune('b')), Concat(Star(ab()), Rune('b')), true},
+		Test{Concat(Star(ab()), Rune('b')), Concat(Star(Rune('a')), Rune('b')), false},
+		// (ab)*a = a(ba)*
+		Test{Concat(Star(Literal("ab")), Rune('a')), Concat(Rune('a'), Star(Literal("ba"))), true},
+		Test{Concat(Rune('a'), Star(Literal("ba"))), Concat(Star(Literal("ab")), Rune('a')), true},
+		Test{Empty(), Star(Rune('a')), true},
+		Test{Star(Rune('a')), Empty(), false},
+		Test{Never(), Never(), true},
+		Test{Rune('a'), Never(), false},
+	}
+	for i, test := range tests {
+		included, word := Includes(test.A, test.B)
+		if included != test.Included {
+			t.Errorf("Test %d: Includes gives %v, expected %v", i, included, test.Included)
+			continue
+		}
+		if !included && (!test.A.Match(word) || test.B.Match(word)) {
+			t.Errorf("Test %d: %q isn't a counterexample", i, word)
+		}
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	ab := func() NFA { return Either(Rune('a'), Rune('b')) }
+	// (a|b)* = (a*b*)*
+	if ok, word := Equivalent(Star(ab()), Star(Concat(Star(Rune('a')), Star(Rune('b'))))); !ok {
+		t.Errorf("(a|b)* and (a*b*)* should be equivalent, differ at %q", word)
+	}
+	a, b := Star(ab()), Star(Literal("ab"))
+	ok, word := Equivalent(a, b)
+	if ok || a.Match(word) == b.Match(word) {
+		t.Errorf("(a|b)* and (ab)* should differ, got %v with %q", ok, word)
+	}
+
+	// the DFA of (a|b)*a(a|b)...(a|b) would need 2^21 states, but the
+	// antichains stay small
+	large := lastCharacters(20)
+	if ok, word := Equivalent(large, Reverse(Reverse(large))); !ok {
+		t.Errorf("Reversing twice should give an equivalent NFA, differ at %q", word)
+	}
+	shorter := lastCharacters(19)
+	ok, word = Equivalent(large, shorter)
+	if ok || large.Match(word) == shorter.Match(word) {
+		t.Errorf("NFAs for different lengths should differ, got %v with %q", ok, word)
+	}
+
+	// Other stands for no valid character once the alphabet holds them all
+	dot, err := Read(strings.NewReader(text_dot))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	every, err := Read(strings.NewReader(text_every))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if ok, word := Equivalent(dot, every); !ok {
+		t.Errorf(". and [^a]|a should be equivalent, differ at %q", word)
+	}
+}
+
+// any character, as in .
+const text_dot = `states 2
+entry 1
+final 2
+alphabet other
+1 2 other
+`
+
+// any valid character, as in [^a]|a
+const text_every = `states 2
+entry 1
+final 2
+alphabet other '\x00'-'` + "`" + `' 'a' 'b'-'\U0010FFFF'
+1 2 '\x00'
+1 2 'a'
+1 2 'b'
+`
+
+// an 'a' followed by any character but 'b'
+const text_other = `states 3
+entry 1
+final 3
+alphabet other 'a' 'b'
+1 2 'a'
+2 3 other
+2 3 'a'
+`
+
+func TestAlphabet(t *testing.T) {
+	nfa, err := Read(strings.NewReader(text_other))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if len(nfa.Alphabet) != 3 || nfa.Alphabet[0] != Other {
+		t.Fatalf("Wrong alphabet: %q", nfa.Alphabet)
+	}
+	var b strings.Builder
+	nfa.Write(&b)
+	if b.String() != text_other {
+		t.Errorf("Writing back changed the text:\n%s", b.String())
+	}
+	d, reverse := nfa.ToDFA(), Reverse(nfa)
+	tests := map[string]bool{"aa": true, "az": true, "aλ": true, "ab": false, "a": false, "za": false, "aaa": false}
+	for word, res := range tests {
+		if nfa.Match(word) != res {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+		if d.Check(word) != res {
+			t.Errorf("Wrong answer of the DFA at: %q", word)
+		}
+		if reverse.Match(reversed(word)) != res {
+			t.Errorf("Wrong answer of the reversed NFA at: %q", word)
+		}
+	}
+
+	// a. over the alphabet of a and nothing else
+	any := Copy(nfa)
+	any.Graph[2]['b'] = []int{3}
+	any.Alphabet = []rune{Other, 'a'}
+	if ok, word := Includes(nfa, any); !ok {
+		t.Errorf("a[^b] should be included in a., differs at %q", word)
+	}
+	if ok, word := Includes(any, nfa); ok || word != "ab" {
+		t.Errorf("a. shouldn't be included in a[^b], got %v with %q", ok, word)
+	}
+	if ok, word := Includes(Literal("az"), nfa); !ok {
+		t.Errorf("az should be included in a[^b], differs at %q", word)
+	}
+	if ok, word := Includes(nfa, Literal("az")); ok || word != "aa" {
+		t.Errorf("a[^b] shouldn't be included in az, got %v with %q", ok, word)
+	}
+}
+
+const text_ranges = `states 2
+entry 1
+final 2
+alphabet 'a'-'l' 'm' 'n'-'z'
+1 1 'a'
+1 2 'm'
+2 2 'n'
+`
+
+func TestRangeAlphabet(t *testing.T) {
+	// [a-l]*m[n-z]*
+	nfa, err := Read(strings.NewReader(text_ranges))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	var b strings.Builder
+	nfa.Write(&b)
+	if b.String() != text_ranges {
+		t.Errorf("Writing back changed the text:\n%s", b.String())
+	}
+	if _, err := Read(strings.NewReader("states 1\n1 1 'a'-'z'\n")); err == nil {
+		t.Errorf("Transitions on ranges shouldn't be read")
+	}
+	if _, err := Read(strings.NewReader("states 1\nalphabet 'z'-'a'\n")); err == nil {
+		t.Errorf("Inverted ranges shouldn't be read")
+	}
+
+	// the ranges holding q and k to o are split when the alphabets are
+	// merged
+	q := Concat(nfa, Rune('q'))
+	class := Either(nfa, Class([]Range{{'k', 'o'}}))
+	tests := map[string][3]bool{
+		"m":    [3]bool{true, false, true},
+		"abm":  [3]bool{true, false, true},
+		"mz":   [3]bool{true, false, true},
+		"mqq":  [3]bool{true, true, true},
+		"mq":   [3]bool{true, true, true},
+		"mr":   [3]bool{true, false, true},
+		"o":    [3]bool{false, false, true},
+		"p":    [3]bool{false, false, false},
+		"mm":   [3]bool{false, false, false},
+		"A":    [3]bool{false, false, false},
+		"lmnq": [3]bool{true, true, true},
+	}
+	automata := []NFA{nfa, q, class}
+	for word, expected := range tests {
+		for i, n := range automata {
+			d := n.ToDFA()
+			if n.Match(word) != expected[i] || d.Check(word) != expected[i] {
+				t.Errorf("Automaton %d: wrong answer at %q", i, word)
+			}
+		}
+	}
+	if string(q.Alphabet) != "amnqr" || q.Ends['n'] != 'p' || q.Ends['r'] != 'z' {
+		t.Errorf("Wrong alphabet: %q, %q", q.Alphabet, q.Ends)
+	}
+	if ok, word := Includes(nfa, class); !ok {
+		t.Errorf("The NFA should be included in the union, differs at %q", word)
+	}
+	if ok, word := Includes(class, nfa); ok || nfa.Match(word) || !class.Match(word) {
+		t.Errorf("The union shouldn't be included in the NFA, got %v with %q", ok, word)
+	}
+}
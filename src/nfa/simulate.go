@@ -0,0 +1,42 @@
+package nfa
+
+import (
+	"stateset"
+)
+
+// Match checks whether the NFA accepts a given word, by simulating it
+// directly instead of turning it into a DFA first. It keeps track of the set
+// of states the NFA may be in after each character, so it runs in
+// O(len(word)·(NumStates+NumTransitions)) time, and it only allocates
+// memory once, before reading the word.
+func (n *NFA) Match(word string) bool {
+	current := stateset.New(n.NumStates + 1)
+	n.addClosure(current, n.EntryState, make([]int, 0, n.NumStates+1))
+	return n.run(current, word)
+}
+
+// run continues the simulation of Match from the given set of states, which
+// must be closed under λ-transitions. The set is overwritten.
+func (n *NFA) run(current *stateset.Set, word string) bool {
+	next := stateset.New(n.NumStates + 1)
+	stack := make([]int, 0, n.NumStates+1)
+	for _, char := range word {
+		next.Clear()
+		char = n.symbol(char)
+		for _, state := range current.Elements() {
+			for _, neighbour := range n.Graph[state][char] {
+				n.addClosure(next, neighbour, stack)
+			}
+		}
+		current, next = next, current
+		if current.Len() == 0 {
+			return false
+		}
+	}
+	for _, state := range current.Elements() {
+		if n.IsFinal(state) {
+			return true
+		}
+	}
+	return false
+}
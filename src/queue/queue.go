@@ -5,7 +5,6 @@ import "fmt"
 // Queue is a basic FIFO queue based on a circular list that resizes as needed.
 type Queue struct {
 	nodes []int
-	size  int
 	head  int
 	tail  int
 	count int
@@ -15,14 +14,14 @@ type Queue struct {
 func New(size int) *Queue {
 	return &Queue{
 		nodes: make([]int, size),
-		size:  size,
 	}
 }
 
-// Push adds a node to the queue.
+// Push adds a node to the queue. A full queue doubles its size, so that
+// pushing takes constant amortized time.
 func (q *Queue) Push(n int) {
-	if q.head == q.tail && q.count > 0 {
-		nodes := make([]int, len(q.nodes)+q.size)
+	if q.count == len(q.nodes) {
+		nodes := make([]int, 2*len(q.nodes)+1)
 		copy(nodes, q.nodes[q.head:])
 		copy(nodes[len(q.nodes)-q.head:], q.nodes[:q.head])
 		q.head = 0
@@ -0,0 +1,130 @@
+package regex
+
+import (
+	"nfa"
+	"queue"
+)
+
+// a single entry of the linear form of an expression: reading Rune leaves
+// Rest to be matched
+type monomial struct {
+	Rune rune
+	Rest *Node
+}
+
+// PartialDerivativeNFA builds the partial-derivative automaton of the given
+// syntax tree (see Antimirov, "Partial derivatives of regular expressions and
+// finite automaton constructions"). Its states are the distinct partial
+// derivatives of the expression, so it has no λ-transitions and, without
+// bounded repetitions, at most one state more than there are characters in
+// the expression. Its alphabet is the same as ThompsonNFA's.
+func PartialDerivativeNFA(node *Node) nfa.NFA {
+	res := nfa.New()
+	alphabet := alphabetOf(node)
+	res.Alphabet, res.Ends = alphabet.symbols, alphabet.ends
+	terms := []*Node{node}
+	ids := map[string]int{node.String(): 1}
+	q := queue.New(1)
+	q.Push(1)
+	for !q.Empty() {
+		id, _ := q.Pop()
+		term := terms[id-1]
+		if term.Nullable() {
+			res.FinalStates = append(res.FinalStates, id)
+		}
+		for _, m := range linearForm(term, alphabet) {
+			key := m.Rest.String()
+			next, ok := ids[key]
+			if !ok {
+				terms = append(terms, m.Rest)
+				next = len(terms)
+				ids[key] = next
+				q.Push(next)
+			}
+			if _, ok := res.Graph[id]; !ok {
+				res.Graph[id] = make(map[rune][]int)
+			}
+			duplicate := false
+			for _, neighbour := range res.Graph[id][m.Rune] {
+				if neighbour == next {
+					duplicate = true
+				}
+			}
+			if !duplicate {
+				res.Graph[id][m.Rune] = append(res.Graph[id][m.Rune], next)
+				res.NumTransitions++
+			}
+		}
+	}
+	res.NumStates = len(terms)
+	res.EntryState = 1
+	return res
+}
+
+// linearForm returns the pairs (a, r) such that the expression matches
+// exactly the words a·w where w is matched by one of the r's, plus the empty
+// word if the expression is nullable. The a's are symbols of the given
+// alphabet, so that classes and '.' give one pair per range of characters.
+func linearForm(n *Node, alphabet symbolTable) []monomial {
+	switch n.Op {
+	case OpLiteral, OpClass, OpAnyChar:
+		symbols := alphabet.within(n)
+		res := make([]monomial, len(symbols))
+		for i, symbol := range symbols {
+			res[i] = monomial{symbol, &Node{Op: OpEmpty}}
+		}
+		return res
+	case OpAlternate:
+		res := make([]monomial, 0)
+		for _, sub := range n.Sub {
+			res = append(res, linearForm(sub, alphabet)...)
+		}
+		return res
+	case OpConcat:
+		rest := concat(n.Sub[1:]...)
+		res := make([]monomial, 0)
+		for _, m := range linearForm(n.Sub[0], alphabet) {
+			res = append(res, monomial{m.Rune, concat(m.Rest, rest)})
+		}
+		if n.Sub[0].Nullable() {
+			res = append(res, linearForm(rest, alphabet)...)
+		}
+		return res
+	case OpStar:
+		res := make([]monomial, 0)
+		for _, m := range linearForm(n.Sub[0], alphabet) {
+			res = append(res, monomial{m.Rune, concat(m.Rest, n)})
+		}
+		return res
+	case OpRepeat:
+		next := n.next()
+		res := make([]monomial, 0)
+		for _, m := range linearForm(n.Sub[0], alphabet) {
+			res = append(res, monomial{m.Rune, concat(m.Rest, next)})
+		}
+		return res
+	}
+	return nil
+}
+
+// concat joins expressions into a flat concatenation, dropping the empty
+// ones, so that equal derivatives end up with equal syntax trees
+func concat(nodes ...*Node) *Node {
+	subs := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		switch node.Op {
+		case OpEmpty:
+		case OpConcat:
+			subs = append(subs, node.Sub...)
+		default:
+			subs = append(subs, node)
+		}
+	}
+	switch len(subs) {
+	case 0:
+		return &Node{Op: OpEmpty}
+	case 1:
+		return subs[0]
+	}
+	return &Node{Op: OpConcat, Sub: subs}
+}
@@ -0,0 +1,220 @@
+package regex
+
+import (
+	"ahocorasick"
+	"context"
+	"dfa"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Engine is the algorithm a compiled regular expression matches words with
+type Engine int
+
+const (
+	// EngineDFA runs the minimized DFA of the expression
+	EngineDFA Engine = iota
+	// EngineBitParallel simulates the Glushkov automaton of the expression
+	// with bit operations, which needs no determinization. It is chosen for
+	// expressions with at most 64 characters.
+	EngineBitParallel
+	// EngineAhoCorasick looks words up in the Aho-Corasick automaton of the
+	// expression, which must be an alternation of plain words such as
+	// foo|bar|baz. It is chosen for every such expression, however many
+	// words it has.
+	EngineAhoCorasick
+	// EngineCounting takes the derivatives of the expression as it reads
+	// the word, keeping repetitions as counters. It is chosen for
+	// expressions whose repetitions would unroll into more than 256
+	// characters, or whose DFA would have more than 10000 states.
+	EngineCounting
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineDFA:
+		return "dfa"
+	case EngineBitParallel:
+		return "bit-parallel"
+	case EngineAhoCorasick:
+		return "aho-corasick"
+	case EngineCounting:
+		return "counting"
+	}
+	return "unknown"
+}
+
+// Regexp is a compiled regular expression, ready to match words
+type Regexp struct {
+	expr   string
+	node   *Node
+	engine Engine
+	bits   *bitParallel
+	words  *ahocorasick.Matcher
+	counts *counting
+	// every match starts with prefix and contains factor
+	literal literalInfo
+	dfa     dfa.DFA
+	// the DFA is only built on demand by the other engines
+	dfaOnce sync.Once
+}
+
+// Compile parses a regular expression and picks the engine to match it
+// with, turning it into a minimized DFA if none of the faster engines can
+// handle it.
+func Compile(re string) (*Regexp, error) {
+	return CompileContext(context.Background(), re, dfa.Limits{})
+}
+
+// CompileContext works like Compile, but gives up as soon as the context is
+// done or the automata go over the given limits. The error is then either
+// the context's error, dfa.ErrTooManyStates or dfa.ErrTooMuchMemory.
+//
+// The faster engines don't build a DFA, so the limits only hold for their
+// own automata: the Aho-Corasick automaton is built within MaxDFAStates and
+// MaxMemory, and MaxNFAStates bounds the NFA of the expression, which has
+// as many states as it has characters, plus one.
+func CompileContext(ctx context.Context, re string, limits dfa.Limits) (*Regexp, error) {
+	node, err := Parse(re)
+	if err != nil {
+		return nil, err
+	}
+	res := &Regexp{expr: re, node: node, literal: literalsOf(node)}
+	if words, ok := literals(node); ok {
+		res.engine = EngineAhoCorasick
+		if res.words, err = ahocorasick.NewContext(ctx, words, limits); err != nil {
+			return nil, err
+		}
+	} else if bits, ok := newBitParallel(node); ok {
+		res.engine = EngineBitParallel
+		res.bits = bits
+	} else if countPositions(node) > maxUnrolled {
+		res.engine = EngineCounting
+		res.counts = &counting{node}
+	}
+	if res.engine == EngineDFA {
+		// the DFA may need exponentially many states, in which case the
+		// counting engine takes over
+		capped := limits
+		if capped.MaxDFAStates == 0 || capped.MaxDFAStates > maxDFAStates {
+			capped.MaxDFAStates = maxDFAStates
+		}
+		nfa := PartialDerivativeNFA(node)
+		res.dfa, err = nfa.ToDFAContext(ctx, capped)
+		exploded := errors.Is(err, dfa.ErrTooManyStates) && capped.MaxDFAStates != limits.MaxDFAStates &&
+			(limits.MaxNFAStates == 0 || nfa.NumStates <= limits.MaxNFAStates)
+		switch {
+		case exploded:
+			res.engine = EngineCounting
+			res.counts = &counting{node}
+			res.dfa = dfa.DFA{}
+		case err != nil:
+			return nil, err
+		default:
+			if err = res.dfa.MinimizeContext(ctx); err != nil {
+				return nil, err
+			}
+			// the DFA is already built, DFA has nothing left to do
+			res.dfaOnce.Do(func() {})
+			return res, nil
+		}
+	}
+
+	if states := countPositions(node) + 1; limits.MaxNFAStates > 0 && states > limits.MaxNFAStates {
+		return nil, fmt.Errorf("%w: the NFA has %d states, at most %d are allowed",
+			dfa.ErrTooManyStates, states, limits.MaxNFAStates)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MustCompile works like Compile, but panics if the regular expression
+// can't be compiled.
+func MustCompile(re string) *Regexp {
+	res, err := Compile(re)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// Match checks whether the regular expression matches the whole word
+func (r *Regexp) Match(word string) bool {
+	switch r.engine {
+	case EngineBitParallel:
+		return r.bits.match(word)
+	case EngineAhoCorasick:
+		return r.words.Match(word)
+	case EngineCounting:
+		return r.counts.match(word)
+	}
+	return r.dfa.Check(word)
+}
+
+// Engine returns the engine that was chosen to match the regular expression
+func (r *Regexp) Engine() Engine {
+	return r.engine
+}
+
+// LiteralPrefix returns a word that every match of the regular expression
+// starts with. complete is true if that word is the only match.
+func (r *Regexp) LiteralPrefix() (prefix string, complete bool) {
+	return r.literal.prefix, r.literal.exact
+}
+
+// RequiredFactor returns a word that every match of the regular expression
+// contains, or "" if it didn't find any.
+func (r *Regexp) RequiredFactor() string {
+	return r.literal.factor
+}
+
+// DFA returns the minimized DFA of the regular expression. If the Regexp
+// doesn't use the DFA engine, the DFA is built on the first call, without
+// any limits.
+func (r *Regexp) DFA() dfa.DFA {
+	r.dfaOnce.Do(func() {
+		if r.engine != EngineDFA {
+			nfa := PartialDerivativeNFA(r.node)
+			r.dfa = nfa.ToDFA()
+			r.dfa.Minimize()
+		}
+	})
+	return r.dfa
+}
+
+// literals returns the words of an expression that is an alternation of
+// non-empty words, or false if it isn't one
+func literals(node *Node) ([]string, bool) {
+	switch node.Op {
+	case OpLiteral:
+		return []string{string(node.Rune)}, true
+	case OpConcat:
+		word := make([]rune, 0, len(node.Sub))
+		for _, sub := range node.Sub {
+			if sub.Op != OpLiteral {
+				return nil, false
+			}
+			word = append(word, sub.Rune)
+		}
+		return []string{string(word)}, true
+	case OpAlternate:
+		res := make([]string, 0, len(node.Sub))
+		for _, sub := range node.Sub {
+			words, ok := literals(sub)
+			if !ok {
+				return nil, false
+			}
+			res = append(res, words...)
+		}
+		return res, true
+	}
+	return nil, false
+}
+
+// String returns the regular expression the Regexp was compiled from
+func (r *Regexp) String() string {
+	return r.expr
+}
@@ -0,0 +1,134 @@
+package regex
+
+import (
+	"unicode/utf8"
+)
+
+// the largest number of characters an expression may have once its
+// repetitions are unrolled, for it to be turned into a DFA
+const maxUnrolled = 256
+
+// the largest number of states the DFA of an expression may have for the
+// DFA engine to match it; past that, its repetitions are counted instead
+const maxDFAStates = 10000
+
+// counting matches words by taking the partial derivatives of the
+// expression character by character, without building any automaton.
+// Repetitions stay counters in the derivatives, so that [a-z]{1000} turns
+// into [a-z]{999}, [a-z]{998}... instead of being unrolled into a thousand
+// copies. The memory used is bounded by the size of the expression times
+// the number of counter values alive at once.
+type counting struct {
+	node *Node
+}
+
+// match checks whether the expression matches the whole word
+func (c *counting) match(word string) bool {
+	terms := []*Node{c.node}
+	for _, char := range word {
+		if terms = derivatives(terms, char); len(terms) == 0 {
+			return false
+		}
+	}
+	return anyNullable(terms)
+}
+
+// longest returns the end of the longest match starting at the given
+// offset of the text, or -1 if there is none
+func (c *counting) longest(text string, start int) int {
+	res := -1
+	if c.node.Nullable() {
+		res = start
+	}
+	terms := []*Node{c.node}
+	for i, char := range text[start:] {
+		if terms = derivatives(terms, char); len(terms) == 0 {
+			break
+		}
+		if anyNullable(terms) {
+			_, size := utf8.DecodeRuneInString(text[start+i:])
+			res = start + i + size
+		}
+	}
+	return res
+}
+
+func anyNullable(terms []*Node) bool {
+	for _, term := range terms {
+		if term.Nullable() {
+			return true
+		}
+	}
+	return false
+}
+
+// derivatives returns the distinct partial derivatives of the terms by the
+// given character
+func derivatives(terms []*Node, char rune) []*Node {
+	seen := make(map[string]bool)
+	res := make([]*Node, 0, len(terms))
+	for _, term := range terms {
+		for _, d := range derive(term, char) {
+			key := d.String()
+			if !seen[key] {
+				seen[key] = true
+				res = append(res, d)
+			}
+		}
+	}
+	return res
+}
+
+// derive returns the partial derivatives of the expression by the given
+// character: the expressions r such that it matches exactly the words
+// char·w where w is matched by one of the r's
+func derive(n *Node, char rune) []*Node {
+	switch n.Op {
+	case OpLiteral, OpClass, OpAnyChar:
+		if n.Matches(char) {
+			return []*Node{&Node{Op: OpEmpty}}
+		}
+	case OpAlternate:
+		res := make([]*Node, 0)
+		for _, sub := range n.Sub {
+			res = append(res, derive(sub, char)...)
+		}
+		return res
+	case OpConcat:
+		rest := concat(n.Sub[1:]...)
+		res := make([]*Node, 0)
+		for _, d := range derive(n.Sub[0], char) {
+			res = append(res, concat(d, rest))
+		}
+		if n.Sub[0].Nullable() {
+			res = append(res, derive(rest, char)...)
+		}
+		return res
+	case OpStar, OpRepeat:
+		next := n
+		if n.Op == OpRepeat {
+			next = n.next()
+		}
+		res := make([]*Node, 0)
+		for _, d := range derive(n.Sub[0], char) {
+			res = append(res, concat(d, next))
+		}
+		return res
+	}
+	return nil
+}
+
+// next returns what's left to match of a repetition after one occurrence
+// of its sub-expression
+func (n *Node) next() *Node {
+	min := n.Min - 1
+	// if the sub-expression is nullable, it can always be repeated less
+	if min < 0 || n.Sub[0].Nullable() {
+		min = 0
+	}
+	max := n.Max
+	if max > 0 {
+		max--
+	}
+	return repeat(n.Sub[0], min, max)
+}
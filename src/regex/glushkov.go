@@ -0,0 +1,197 @@
+package regex
+
+import (
+	"math/bits"
+	"unicode/utf8"
+)
+
+// the largest number of positions a bitParallel matcher can handle, one per
+// bit of a machine word
+const maxPositions = 64
+
+// bitParallel simulates the Glushkov automaton of an expression, whose
+// states are the positions of the characters in the expression (see
+// Navarro and Raffinot, "Fast and flexible string matching by combining
+// bit-parallelism and suffix automata"). The set of active positions fits
+// in a single machine word, so every character costs a handful of bit
+// operations and table lookups, with no determinization beforehand.
+type bitParallel struct {
+	// positions that can start and end a match
+	first, last uint64
+	nullable    bool
+	// masks[c] has the bits of the positions of character c set
+	masks map[rune]uint64
+	// the positions of the dots, and of the classes along with their nodes
+	any     uint64
+	classes []classPosition
+	// follow[k][b] is the set of positions that can come after any of the
+	// positions 8k..8k+7 whose bits are set in b
+	follow [][256]uint64
+}
+
+type classPosition struct {
+	node *Node
+	bit  uint64
+}
+
+// newBitParallel builds the bit-parallel matcher of an expression, or
+// returns false if it has more than maxPositions characters
+func newBitParallel(node *Node) (*bitParallel, bool) {
+	positions := countPositions(node)
+	if positions > maxPositions {
+		return nil, false
+	}
+	res := &bitParallel{masks: make(map[rune]uint64)}
+	follow := make([]uint64, maxPositions)
+	pos := 0
+	res.first, res.last, res.nullable = res.glushkov(node, &pos, follow)
+
+	res.follow = make([][256]uint64, (positions+7)/8)
+	for k := range res.follow {
+		for b := 1; b < 256; b++ {
+			lowest := bits.TrailingZeros(uint(b))
+			res.follow[k][b] = res.follow[k][b&(b-1)] | follow[8*k+lowest]
+		}
+	}
+	return res, true
+}
+
+// countPositions returns the number of characters of the expression once
+// its repetitions are unrolled, stopping at maxRepeat * maxRepeat so as not
+// to overflow
+func countPositions(node *Node) int {
+	res := 0
+	switch node.Op {
+	case OpLiteral, OpClass, OpAnyChar:
+		res = 1
+	case OpRepeat:
+		copies := node.Max
+		if copies < 0 {
+			copies = node.Min + 1
+		}
+		res = countPositions(node.Sub[0]) * copies
+	default:
+		for _, sub := range node.Sub {
+			res += countPositions(sub)
+		}
+	}
+	if res > maxRepeat*maxRepeat {
+		res = maxRepeat * maxRepeat
+	}
+	return res
+}
+
+// glushkov numbers the characters of the expression from *pos onwards and
+// returns the positions that can start and end its words, and whether it
+// is nullable. The follow sets of the positions are updated along the way.
+func (g *bitParallel) glushkov(node *Node, pos *int, follow []uint64) (first, last uint64, nullable bool) {
+	switch node.Op {
+	case OpLiteral, OpClass, OpAnyChar:
+		bit := uint64(1) << uint(*pos)
+		*pos++
+		switch node.Op {
+		case OpLiteral:
+			g.masks[node.Rune] |= bit
+		case OpClass:
+			g.classes = append(g.classes, classPosition{node, bit})
+		case OpAnyChar:
+			g.any |= bit
+		}
+		return bit, bit, false
+	case OpRepeat:
+		return g.glushkov(unroll(node), pos, follow)
+	case OpConcat:
+		first, last, nullable = g.glushkov(node.Sub[0], pos, follow)
+		for _, sub := range node.Sub[1:] {
+			f, l, n := g.glushkov(sub, pos, follow)
+			addFollow(follow, last, f)
+			if nullable {
+				first |= f
+			}
+			if n {
+				last |= l
+			} else {
+				last = l
+			}
+			nullable = nullable && n
+		}
+		return first, last, nullable
+	case OpAlternate:
+		for _, sub := range node.Sub {
+			f, l, n := g.glushkov(sub, pos, follow)
+			first, last, nullable = first|f, last|l, nullable || n
+		}
+		return first, last, nullable
+	case OpStar:
+		first, last, _ = g.glushkov(node.Sub[0], pos, follow)
+		addFollow(follow, last, first)
+		return first, last, true
+	}
+	return 0, 0, true
+}
+
+// addFollow adds next to the follow sets of the given positions
+func addFollow(follow []uint64, positions, next uint64) {
+	for ; positions != 0; positions &= positions - 1 {
+		follow[bits.TrailingZeros64(positions)] |= next
+	}
+}
+
+// mask returns the positions that match the given character
+func (g *bitParallel) mask(char rune) uint64 {
+	res := g.masks[char] | g.any
+	for _, c := range g.classes {
+		if c.node.Matches(char) {
+			res |= c.bit
+		}
+	}
+	return res
+}
+
+// step returns the positions that can come after any of the given ones
+func (g *bitParallel) step(state uint64) uint64 {
+	var res uint64
+	for k := range g.follow {
+		res |= g.follow[k][byte(state>>uint(8*k))]
+	}
+	return res
+}
+
+// match checks whether the expression matches the whole word
+func (g *bitParallel) match(word string) bool {
+	if word == "" {
+		return g.nullable
+	}
+	reach := g.first
+	var state uint64
+	for _, char := range word {
+		state = reach & g.mask(char)
+		if state == 0 {
+			return false
+		}
+		reach = g.step(state)
+	}
+	return state&g.last != 0
+}
+
+// longest returns the end of the longest match starting at the given
+// offset of the text, or -1 if there is none
+func (g *bitParallel) longest(text string, start int) int {
+	res := -1
+	if g.nullable {
+		res = start
+	}
+	reach := g.first
+	for i, char := range text[start:] {
+		state := reach & g.mask(char)
+		if state == 0 {
+			break
+		}
+		if state&g.last != 0 {
+			_, size := utf8.DecodeRuneInString(text[start+i:])
+			res = start + i + size
+		}
+		reach = g.step(state)
+	}
+	return res
+}
@@ -0,0 +1,245 @@
+package regex
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// the longest prefix, suffix or factor kept, in bytes; longer ones are cut,
+// as repetitions would otherwise build huge words before any limit is
+// checked
+const maxLiteral = 1 << 11
+
+// the largest width tracked; wider words are counted as this wide, which
+// is more than enough for the prefilter to skip nothing
+const maxWidth = math.MaxInt32
+
+// literalInfo sums up the words an expression matches, as far as plain
+// strings go: every word starts with prefix, ends with suffix and contains
+// factor. If exact is set, the expression matches the single word prefix.
+// width is the length in bytes of the longest word, or -1 if there is no
+// longest word.
+type literalInfo struct {
+	exact                  bool
+	prefix, suffix, factor string
+	width                  int
+}
+
+// literalsOf works out the literal prefix, suffix and required factor of an
+// expression, in the manner of the "must" analysis of grep
+func literalsOf(node *Node) literalInfo {
+	res := mustOf(node)
+	res.width = widthOf(node)
+	return res
+}
+
+// mustOf works out everything but the width
+func mustOf(node *Node) literalInfo {
+	switch node.Op {
+	case OpEmpty:
+		return literalInfo{exact: true}
+	case OpLiteral:
+		word := string(node.Rune)
+		return literalInfo{exact: true, prefix: word, suffix: word, factor: word}
+	case OpConcat:
+		res := mustOf(node.Sub[0])
+		for _, sub := range node.Sub[1:] {
+			next := mustOf(sub)
+			factor := longestOf(res.factor, next.factor, res.suffix+next.prefix)
+			if res.exact {
+				res.prefix += next.prefix
+			}
+			if next.exact {
+				res.suffix += next.suffix
+			} else {
+				res.suffix = next.suffix
+			}
+			res.exact = res.exact && next.exact
+			res.factor = factor
+			res = res.cut()
+		}
+		return res
+	case OpAlternate:
+		res := mustOf(node.Sub[0])
+		for _, sub := range node.Sub[1:] {
+			next := mustOf(sub)
+			res.exact = res.exact && next.exact && res.prefix == next.prefix
+			res.prefix = commonPrefix(res.prefix, next.prefix)
+			res.suffix = commonSuffix(res.suffix, next.suffix)
+			// a substring of a required factor is required as well
+			res.factor = longestOf(commonFactor(res.factor, next.factor), res.prefix, res.suffix)
+		}
+		return res
+	case OpRepeat:
+		if node.Min == 0 {
+			break
+		}
+		res := mustOf(node.Sub[0])
+		if res.exact {
+			// repeating the word past maxLiteral is only cut afterwards
+			count := node.Min
+			if len(res.prefix) > 0 && count > maxLiteral/len(res.prefix)+1 {
+				count = maxLiteral/len(res.prefix) + 1
+			}
+			word := strings.Repeat(res.prefix, count)
+			exact := node.Min == node.Max && count == node.Min
+			return literalInfo{exact: exact, prefix: word, suffix: word, factor: word}.cut()
+		}
+		return res
+	}
+	return literalInfo{}
+}
+
+// cut shortens the prefix, suffix and factor to at most maxLiteral bytes,
+// keeping whole characters. The expression isn't exact anymore if its word
+// was cut.
+func (l literalInfo) cut() literalInfo {
+	if len(l.prefix) > maxLiteral {
+		i := maxLiteral
+		for !utf8.RuneStart(l.prefix[i]) {
+			i--
+		}
+		l.prefix = l.prefix[:i]
+		l.exact = false
+	}
+	if len(l.suffix) > maxLiteral {
+		i := len(l.suffix) - maxLiteral
+		for !utf8.RuneStart(l.suffix[i]) {
+			i++
+		}
+		l.suffix = l.suffix[i:]
+	}
+	if len(l.factor) > maxLiteral {
+		i := maxLiteral
+		for !utf8.RuneStart(l.factor[i]) {
+			i--
+		}
+		l.factor = l.factor[:i]
+	}
+	return l
+}
+
+// widthOf returns the length in bytes of the longest word matched by an
+// expression, up to maxWidth, or -1 if there is no longest word
+func widthOf(node *Node) int {
+	switch node.Op {
+	case OpEmpty:
+		return 0
+	case OpLiteral:
+		return runeWidth(node.Rune)
+	case OpAnyChar:
+		return utf8.UTFMax
+	case OpClass:
+		res := 0
+		for _, r := range node.Ranges {
+			if size := runeWidth(r.Hi); size > res {
+				res = size
+			}
+		}
+		return res
+	case OpConcat:
+		res := 0
+		for _, sub := range node.Sub {
+			width := widthOf(sub)
+			if width < 0 {
+				return -1
+			}
+			if width > maxWidth-res {
+				res = maxWidth
+			} else {
+				res += width
+			}
+		}
+		return res
+	case OpAlternate:
+		res := 0
+		for _, sub := range node.Sub {
+			width := widthOf(sub)
+			if width < 0 {
+				return -1
+			}
+			if width > res {
+				res = width
+			}
+		}
+		return res
+	case OpRepeat:
+		width := widthOf(node.Sub[0])
+		if width == 0 {
+			return 0
+		}
+		if node.Max < 0 || width < 0 {
+			return -1
+		}
+		if width > maxWidth/node.Max {
+			return maxWidth
+		}
+		return width * node.Max
+	case OpStar:
+		if widthOf(node.Sub[0]) == 0 {
+			return 0
+		}
+	}
+	return -1
+}
+
+// runeWidth returns the number of bytes of a character in UTF-8, counting
+// those that can't be encoded as the widest ones
+func runeWidth(char rune) int {
+	if size := utf8.RuneLen(char); size > 0 {
+		return size
+	}
+	return utf8.UTFMax
+}
+
+// longestOf returns the longest of the given words, the first one on ties
+func longestOf(words ...string) string {
+	res := ""
+	for _, word := range words {
+		if len(word) > len(res) {
+			res = word
+		}
+	}
+	return res
+}
+
+func commonPrefix(a, b string) string {
+	x, y := []rune(a), []rune(b)
+	i := 0
+	for i < len(x) && i < len(y) && x[i] == y[i] {
+		i++
+	}
+	return string(x[:i])
+}
+
+func commonSuffix(a, b string) string {
+	x, y := []rune(a), []rune(b)
+	i := 0
+	for i < len(x) && i < len(y) && x[len(x)-1-i] == y[len(y)-1-i] {
+		i++
+	}
+	return string(x[len(x)-i:])
+}
+
+// commonFactor returns the longest word that is a factor of both a and b
+func commonFactor(a, b string) string {
+	x, y := []rune(a), []rune(b)
+	// length[j] is the length of the longest common suffix of x[:i] and
+	// y[:j], for the current i
+	length := make([]int, len(y)+1)
+	best, end := 0, 0
+	for i := 1; i <= len(x); i++ {
+		for j := len(y); j >= 1; j-- {
+			if x[i-1] == y[j-1] {
+				length[j] = length[j-1] + 1
+				if length[j] > best {
+					best, end = length[j], i
+				}
+			} else {
+				length[j] = 0
+			}
+		}
+	}
+	return string(x[end-best : end])
+}
@@ -0,0 +1,536 @@
+package regex
+
+import (
+	"fmt"
+	"nfa"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Op is the kind of a node in the syntax tree of a regular expression
+type Op int
+
+const (
+	// OpEmpty matches the empty word only
+	OpEmpty Op = iota
+	// OpLiteral matches the single character Rune
+	OpLiteral
+	// OpConcat matches its sub-expressions one after the other
+	OpConcat
+	// OpAlternate matches any one of its sub-expressions
+	OpAlternate
+	// OpStar matches zero or more occurrences of its only sub-expression
+	OpStar
+	// OpClass matches any single character from Ranges
+	OpClass
+	// OpAnyChar matches any single character
+	OpAnyChar
+	// OpRepeat matches between Min and Max occurrences of its only
+	// sub-expression, or at least Min if Max is -1
+	OpRepeat
+)
+
+// Node is a node in the syntax tree of a regular expression
+type Node struct {
+	Op       Op
+	Rune     rune
+	Ranges   []nfa.Range
+	Min, Max int
+	Sub      []*Node
+}
+
+// characters that have to be escaped with a backslash to stand for
+// themselves
+const metacharacters = `()|*\.[]{}`
+
+// the largest bound allowed in a repetition
+const maxRepeat = 1 << 20
+
+// Parse turns a regular expression into its syntax tree. It understands
+// parantheses, the Kleene star, the OR operator, character classes such as
+// [a-z0-9] or [^a-z], the dot matching any character and bounded
+// repetitions such as a{3}, a{2,5} or a{2,}. \x{10FFFF} stands for the
+// character with the given hexadecimal code; every other character stands
+// for itself, and so does any character after a backslash, or a '{' that
+// doesn't start a repetition.
+func Parse(re string) (*Node, error) {
+	p := parser{input: []rune(re)}
+	node, err := p.alternate()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("Unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return node, nil
+}
+
+type parser struct {
+	input []rune
+	pos   int
+}
+
+func (p *parser) peek() (rune, bool) {
+	if p.pos >= len(p.input) {
+		return 0, false
+	}
+	return p.input[p.pos], true
+}
+
+// alternate := concat ('|' concat)*
+func (p *parser) alternate() (*Node, error) {
+	subs := make([]*Node, 0, 1)
+	for {
+		node, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, node)
+		if char, ok := p.peek(); !ok || char != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(subs) == 1 {
+		return subs[0], nil
+	}
+	return &Node{Op: OpAlternate, Sub: subs}, nil
+}
+
+// concat := star*
+func (p *parser) concat() (*Node, error) {
+	subs := make([]*Node, 0)
+	for {
+		char, ok := p.peek()
+		if !ok || char == '|' || char == ')' {
+			break
+		}
+		node, err := p.star()
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, node)
+	}
+	return concat(subs...), nil
+}
+
+// star := atom ('*' | repeat)*
+func (p *parser) star() (*Node, error) {
+	node, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		char, ok := p.peek()
+		if !ok || (char != '*' && char != '{') {
+			break
+		}
+		if char == '{' {
+			min, max, ok, err := p.repeat()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// the '{' is read as a character by the next atom
+				break
+			}
+			node = repeat(node, min, max)
+			continue
+		}
+		p.pos++
+		if node.Op != OpStar {
+			node = &Node{Op: OpStar, Sub: []*Node{node}}
+		}
+	}
+	return node, nil
+}
+
+// repeat := '{' number (',' number?)? '}'
+//
+// ok is false, and nothing is read, if the input doesn't start with a
+// repetition, as a '{' that doesn't start one stands for itself.
+func (p *parser) repeat() (min, max int, ok bool, err error) {
+	start := p.pos
+	end := start
+	for end < len(p.input) && p.input[end] != '}' {
+		end++
+	}
+	if end == len(p.input) {
+		return 0, 0, false, nil
+	}
+	bounds := strings.SplitN(string(p.input[start+1:end]), ",", 2)
+	if !isNumber(bounds[0]) || (len(bounds) == 2 && bounds[1] != "" && !isNumber(bounds[1])) {
+		return 0, 0, false, nil
+	}
+	p.pos = end + 1
+	// the only error left is a number too large for an int
+	if min, err = strconv.Atoi(bounds[0]); err != nil {
+		min = maxRepeat + 1
+	}
+	max = min
+	if len(bounds) == 2 {
+		if bounds[1] == "" {
+			max = -1
+		} else if max, err = strconv.Atoi(bounds[1]); err != nil {
+			max = maxRepeat + 1
+		}
+	}
+	if min > maxRepeat || max > maxRepeat {
+		return 0, 0, false, fmt.Errorf("Repetition over %d at position %d", maxRepeat, start)
+	}
+	if max >= 0 && max < min {
+		return 0, 0, false, fmt.Errorf("Invalid repetition at position %d", start)
+	}
+	return min, max, true, nil
+}
+
+// isNumber checks whether the word is made of decimal digits only
+func isNumber(word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, char := range word {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// atom := '(' alternate ')' | class | '.' | '\\' character | character
+func (p *parser) atom() (*Node, error) {
+	char, _ := p.peek()
+	switch char {
+	case '*':
+		return nil, fmt.Errorf("Missing expression before %q at position %d", char, p.pos)
+	case '{':
+		if _, _, ok, err := p.repeat(); ok || err != nil {
+			return nil, fmt.Errorf("Missing expression before %q at position %d", char, p.pos)
+		}
+	case '[':
+		return p.class()
+	case '.':
+		p.pos++
+		return &Node{Op: OpAnyChar}, nil
+	case '\\':
+		p.pos++
+		char, err := p.escaped()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Op: OpLiteral, Rune: char}, nil
+	case '(':
+		start := p.pos
+		p.pos++
+		node, err := p.alternate()
+		if err != nil {
+			return nil, err
+		}
+		if char, ok := p.peek(); !ok || char != ')' {
+			return nil, fmt.Errorf("Unclosed '(' at position %d", start)
+		}
+		p.pos++
+		return node, nil
+	}
+	p.pos++
+	return &Node{Op: OpLiteral, Rune: char}, nil
+}
+
+// class := '[' '^'? (character ('-' character)?)+ ']'
+//
+// A class starting with '^' matches the characters that aren't in it.
+func (p *parser) class() (*Node, error) {
+	start := p.pos
+	p.pos++
+	negated := false
+	if char, _ := p.peek(); char == '^' {
+		negated = true
+		p.pos++
+	}
+	ranges := make([]nfa.Range, 0, 1)
+	for {
+		lo, ok, err := p.classChar()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		hi := lo
+		if char, _ := p.peek(); char == '-' && p.pos+1 < len(p.input) && p.input[p.pos+1] != ']' {
+			p.pos++
+			if hi, _, err = p.classChar(); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("Invalid range %q-%q in class at position %d", lo, hi, start)
+			}
+		}
+		ranges = append(ranges, nfa.Range{Lo: lo, Hi: hi})
+	}
+	if char, ok := p.peek(); !ok || char != ']' {
+		return nil, fmt.Errorf("Unclosed '[' at position %d", start)
+	}
+	p.pos++
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("Empty class at position %d", start)
+	}
+	if negated {
+		if ranges = complement(merge(ranges)); len(ranges) == 0 {
+			return nil, fmt.Errorf("Class matching no character at position %d", start)
+		}
+	}
+	return class(ranges), nil
+}
+
+// classChar reads a character inside a class, returning false at its end
+func (p *parser) classChar() (rune, bool, error) {
+	char, ok := p.peek()
+	if !ok || char == ']' {
+		return 0, false, nil
+	}
+	p.pos++
+	if char != '\\' {
+		return char, true, nil
+	}
+	char, err := p.escaped()
+	if err != nil {
+		return 0, false, err
+	}
+	return char, true, nil
+}
+
+// escaped reads the character after a backslash, which is either the code
+// of a character, as in \x{e9}, or a character standing for itself
+func (p *parser) escaped() (rune, error) {
+	char, ok := p.peek()
+	if !ok {
+		return 0, fmt.Errorf("Trailing backslash at position %d", p.pos-1)
+	}
+	p.pos++
+	if char != 'x' || p.pos >= len(p.input) || p.input[p.pos] != '{' {
+		return char, nil
+	}
+	end := p.pos + 1
+	for end < len(p.input) && p.input[end] != '}' {
+		end++
+	}
+	if end == len(p.input) {
+		return char, nil
+	}
+	// like a '{' that doesn't start a repetition, one that isn't followed
+	// by a code leaves the 'x' standing for itself
+	code, err := strconv.ParseUint(string(p.input[p.pos+1:end]), 16, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err != strconv.ErrRange {
+			return char, nil
+		}
+		code = utf8.MaxRune + 1
+	}
+	if code > utf8.MaxRune {
+		return 0, fmt.Errorf("Invalid character code at position %d", p.pos-2)
+	}
+	p.pos = end + 1
+	return rune(code), nil
+}
+
+// class returns a class matching the given ranges, sorted and merged so
+// that equal classes give equal syntax trees
+func class(ranges []nfa.Range) *Node {
+	merged := merge(ranges)
+	if len(merged) == 1 && merged[0].Lo == merged[0].Hi {
+		return &Node{Op: OpLiteral, Rune: merged[0].Lo}
+	}
+	return &Node{Op: OpClass, Ranges: merged}
+}
+
+// merge sorts the ranges and merges those that overlap or touch
+func merge(ranges []nfa.Range) []nfa.Range {
+	sorted := make([]nfa.Range, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Lo < sorted[j].Lo })
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Lo <= last.Hi+1 {
+			if r.Hi > last.Hi {
+				last.Hi = r.Hi
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// complement returns the ranges of the characters, up to utf8.MaxRune, that
+// aren't in the given sorted and merged ranges
+func complement(ranges []nfa.Range) []nfa.Range {
+	res := make([]nfa.Range, 0, len(ranges)+1)
+	next := rune(0)
+	for _, r := range ranges {
+		if r.Lo > next {
+			res = append(res, nfa.Range{Lo: next, Hi: r.Lo - 1})
+		}
+		next = r.Hi + 1
+	}
+	if next <= utf8.MaxRune {
+		res = append(res, nfa.Range{Lo: next, Hi: utf8.MaxRune})
+	}
+	return res
+}
+
+// repeat returns an expression matching between min and max occurrences of
+// the given one, or at least min if max is -1
+func repeat(node *Node, min, max int) *Node {
+	switch {
+	case max == 0 || node.Op == OpEmpty:
+		return &Node{Op: OpEmpty}
+	case min == 1 && max == 1:
+		return node
+	case min == 0 && max == -1:
+		return &Node{Op: OpStar, Sub: []*Node{node}}
+	}
+	return &Node{Op: OpRepeat, Min: min, Max: max, Sub: []*Node{node}}
+}
+
+// Matches checks whether a class, a literal or the dot matches the
+// given character
+func (n *Node) Matches(char rune) bool {
+	switch n.Op {
+	case OpLiteral:
+		return n.Rune == char
+	case OpAnyChar:
+		return true
+	case OpClass:
+		i := sort.Search(len(n.Ranges), func(i int) bool { return n.Ranges[i].Hi >= char })
+		return i < len(n.Ranges) && n.Ranges[i].Lo <= char
+	}
+	return false
+}
+
+// Nullable returns true if the expression matches the empty word
+func (n *Node) Nullable() bool {
+	switch n.Op {
+	case OpEmpty, OpStar:
+		return true
+	case OpRepeat:
+		return n.Min == 0 || n.Sub[0].Nullable()
+	case OpConcat:
+		for _, sub := range n.Sub {
+			if !sub.Nullable() {
+				return false
+			}
+		}
+		return true
+	case OpAlternate:
+		for _, sub := range n.Sub {
+			if sub.Nullable() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String turns the syntax tree back into a regular expression, using as few
+// parantheses as possible. Equal trees give equal strings.
+func (n *Node) String() string {
+	var b strings.Builder
+	n.write(&b)
+	return b.String()
+}
+
+// precedence of the operators, from the loosest to the tightest binding
+func (n *Node) precedence() int {
+	switch n.Op {
+	case OpAlternate:
+		return 0
+	case OpConcat:
+		return 1
+	case OpStar, OpRepeat:
+		return 2
+	}
+	return 3
+}
+
+func (n *Node) write(b *strings.Builder) {
+	switch n.Op {
+	case OpEmpty:
+		b.WriteString("()")
+	case OpLiteral:
+		writeChar(b, n.Rune, metacharacters)
+	case OpConcat, OpAlternate:
+		for i, sub := range n.Sub {
+			if i > 0 && n.Op == OpAlternate {
+				b.WriteRune('|')
+			}
+			sub.writeWrapped(b, n.precedence()+1)
+		}
+	case OpStar:
+		n.Sub[0].writeWrapped(b, n.precedence())
+		b.WriteRune('*')
+	case OpRepeat:
+		n.Sub[0].writeWrapped(b, n.precedence())
+		switch n.Max {
+		case n.Min:
+			fmt.Fprintf(b, "{%d}", n.Min)
+		case -1:
+			fmt.Fprintf(b, "{%d,}", n.Min)
+		default:
+			fmt.Fprintf(b, "{%d,%d}", n.Min, n.Max)
+		}
+	case OpAnyChar:
+		b.WriteRune('.')
+	case OpClass:
+		b.WriteRune('[')
+		ranges := n.Ranges
+		// a class holding the first and the last characters is written
+		// as the negation of the others, if there are any
+		last := ranges[len(ranges)-1]
+		if others := complement(ranges); ranges[0].Lo == 0 && last.Hi == utf8.MaxRune && len(others) > 0 {
+			b.WriteRune('^')
+			ranges = others
+		}
+		for _, r := range ranges {
+			writeClassChar(b, r.Lo)
+			if r.Hi > r.Lo {
+				b.WriteRune('-')
+				writeClassChar(b, r.Hi)
+			}
+		}
+		b.WriteRune(']')
+	}
+}
+
+// writeWrapped writes the expression, in parantheses if it binds looser
+// than the given precedence
+func (n *Node) writeWrapped(b *strings.Builder, precedence int) {
+	if n.Op != OpEmpty && n.precedence() < precedence {
+		b.WriteRune('(')
+		n.write(b)
+		b.WriteRune(')')
+		return
+	}
+	n.write(b)
+}
+
+func writeClassChar(b *strings.Builder, char rune) {
+	writeChar(b, char, `\]-^`)
+}
+
+// writeChar writes a character, escaping it if it's one of the given
+// metacharacters, or by its code if it can't be printed
+func writeChar(b *strings.Builder, char rune, metacharacters string) {
+	if !unicode.IsPrint(char) {
+		fmt.Fprintf(b, `\x{%x}`, char)
+		return
+	}
+	if strings.ContainsRune(metacharacters, char) {
+		b.WriteRune('\\')
+	}
+	b.WriteRune(char)
+}
@@ -1,108 +1,132 @@
 package regex
 
 import (
+	"dfa"
 	"nfa"
+	"sort"
 )
 
+// RegexToNFA turns a regular expression into a λ-NFA, using Thompson's
+// construction. It panics if the regular expression can't be parsed.
 func RegexToNFA(re string) nfa.NFA {
-	if len(re) == 0 {
-		return nfa.New()
+	node, err := Parse(re)
+	if err != nil {
+		panic(err)
 	}
-	if re[0] == '(' && re[len(re)-1] == ')' {
-		return RegexToNFA(re[1 : len(re)-1])
-	}
-	first_star_pos := -1
-	first_pipe_pos := -1
-	first_star_nesting := 1 << 30
-	first_pipe_nesting := 1 << 30
-	nesting := 0
-	for pos, char := range re {
-		if char == '(' {
-			nesting++
-		} else if char == ')' {
-			nesting--
-		} else if char == '|' {
-			if nesting < first_pipe_nesting {
-				first_pipe_nesting = nesting
-				first_pipe_pos = pos
-			}
-		} else if char == '*' {
-			if nesting < first_star_nesting {
-				first_star_nesting = nesting
-				first_star_pos = pos
-			}
+	return ThompsonNFA(node)
+}
+
+// ThompsonNFA turns a syntax tree into a λ-NFA, using Thompson's
+// construction. Every node of the tree adds at most two states, so the NFA
+// is built in time linear in the size of the tree once repetitions are
+// unrolled. Its alphabet is the one alphabetOf gives.
+func ThompsonNFA(node *Node) nfa.NFA {
+	b := nfa.NewBuilder()
+	alphabet := alphabetOf(node)
+	res := b.Build(thompson(b, node, alphabet))
+	res.Alphabet, res.Ends = alphabet.symbols, alphabet.ends
+	return res
+}
+
+func thompson(b *nfa.Builder, node *Node, alphabet symbolTable) nfa.Fragment {
+	switch node.Op {
+	case OpLiteral:
+		return b.Rune(node.Rune)
+	case OpConcat:
+		res := thompson(b, node.Sub[0], alphabet)
+		for _, sub := range node.Sub[1:] {
+			res = b.Concat(res, thompson(b, sub, alphabet))
 		}
+		return res
+	case OpAlternate:
+		res := thompson(b, node.Sub[0], alphabet)
+		for _, sub := range node.Sub[1:] {
+			res = b.Either(res, thompson(b, sub, alphabet))
+		}
+		return res
+	case OpStar:
+		return b.Star(thompson(b, node.Sub[0], alphabet))
+	case OpRepeat:
+		return thompson(b, unroll(node), alphabet)
+	case OpClass, OpAnyChar:
+		symbols := alphabet.within(node)
+		ranges := make([]nfa.Range, len(symbols))
+		for i, symbol := range symbols {
+			ranges[i] = nfa.Range{Lo: symbol, Hi: symbol}
+		}
+		return b.Class(ranges)
 	}
-	if first_pipe_pos != -1 && first_pipe_nesting == 0 {
-		n1 := RegexToNFA(re[0:first_pipe_pos])
-		n2 := RegexToNFA(re[first_pipe_pos+1:])
-		return nfa.Either(n1, n2)
-	} else if first_star_pos != -1 && first_star_nesting == 0 {
-		i := 0
-		if re[first_star_pos-1] == ')' {
-			reverse_nesting := 1
-			i = first_star_pos - 2
-			for ; ; i-- {
-				if re[i] == ')' {
-					reverse_nesting++
-				} else if re[i] == '(' {
-					reverse_nesting--
-					if reverse_nesting == 0 {
-						break
-					}
-				}
+	return b.Empty()
+}
+
+// symbolTable is the alphabet of the automata of some expressions: symbols
+// lists nfa.Other and the first characters of ranges, in increasing order,
+// and ends gives the last character of the ranges of more than one
+type symbolTable struct {
+	symbols []rune
+	ends    map[rune]rune
+}
+
+// alphabetOf returns the alphabet of the expressions: the characters
+// written in them, alone or in classes, are split into the largest ranges
+// that behave the same way in every literal and class, and nfa.Other stands
+// for every other character, as only '.' matches them.
+func alphabetOf(nodes ...*Node) symbolTable {
+	ranges := make([]dfa.Range, 0)
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		switch n.Op {
+		case OpLiteral:
+			ranges = append(ranges, dfa.Range{Lo: n.Rune, Hi: n.Rune})
+		case OpClass:
+			for _, r := range n.Ranges {
+				ranges = append(ranges, dfa.Range(r))
 			}
-		} else {
-			i = first_star_pos - 1
 		}
-		res := nfa.New()
-		if i > 0 {
-			n1 := RegexToNFA(re[0:i])
-			n2 := RegexToNFA(re[i:first_star_pos])
-			res = nfa.Concat(n1, nfa.Star(n2))
-		} else {
-			res = nfa.Star(RegexToNFA(re[i:first_star_pos]))
+		for _, sub := range n.Sub {
+			walk(sub)
 		}
-		if len(re[first_star_pos+1:]) > 0 {
-			n2 := RegexToNFA(re[first_star_pos+1:])
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+	symbols, ends := dfa.Refine(ranges, true)
+	return symbolTable{symbols, ends}
+}
 
-			res = nfa.Concat(res, n2)
-		}
-		return res
-	} else {
-		if re[0] == '(' {
-			nesting = 1
-			i := 1
-			for ; ; i++ {
-				if re[i] == '(' {
-					nesting++
-				} else if re[i] == ')' {
-					nesting--
-					if nesting <= 0 {
-						break
-					}
-				}
-			}
-			return nfa.Concat(RegexToNFA(re[1:i]), RegexToNFA(re[i+1:]))
-		} else {
-			res := nfa.New()
-			node := 1
-			res.EntryState = 1
-			res.NumStates = 1
-			res.FinalStates = []int{1}
-			for pos, char := range re {
-				if char == '(' {
-					res.FinalStates = []int{node}
-					return nfa.Concat(res, RegexToNFA(re[pos:]))
-				}
-				res.Graph[node] = map[rune][]int{char: []int{node + 1}}
-				res.NumStates++
-				res.NumTransitions++
-				node++
-			}
-			res.FinalStates = []int{node}
-			return res
+// within returns the symbols of the alphabet matched by a literal, a class
+// or '.'. A symbol's range is either inside a class or outside of it, so
+// only its first character needs checking.
+func (a symbolTable) within(n *Node) []rune {
+	switch n.Op {
+	case OpLiteral:
+		return []rune{n.Rune}
+	case OpAnyChar:
+		return a.symbols
+	}
+	res := make([]rune, 0)
+	for _, r := range n.Ranges {
+		i := sort.Search(len(a.symbols), func(i int) bool { return a.symbols[i] >= r.Lo })
+		for ; i < len(a.symbols) && a.symbols[i] <= r.Hi; i++ {
+			res = append(res, a.symbols[i])
 		}
 	}
-	return nfa.New()
+	return res
+}
+
+// unroll writes a repetition out as copies of its sub-expression: a{2,4}
+// becomes aa(a|())(a|()) and a{2,} becomes aaa*
+func unroll(node *Node) *Node {
+	sub := node.Sub[0]
+	subs := make([]*Node, 0, node.Min+1)
+	for i := 0; i < node.Min; i++ {
+		subs = append(subs, sub)
+	}
+	if node.Max < 0 {
+		return concat(append(subs, &Node{Op: OpStar, Sub: []*Node{sub}})...)
+	}
+	for i := node.Min; i < node.Max; i++ {
+		subs = append(subs, &Node{Op: OpAlternate, Sub: []*Node{sub, &Node{Op: OpEmpty}}})
+	}
+	return concat(subs...)
 }
@@ -1,8 +1,16 @@
 package regex
 
 import (
+	"context"
+	"dfa"
+	"errors"
+	"fmt"
+	"nfa"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestRegexCheck(t *testing.T) {
@@ -22,6 +30,12 @@ func TestRegexCheck(t *testing.T) {
 		Test{"((a|b*)c)*", "accccccc", true},
 		Test{"((a|b*)c)*", "aaccccc", false},
 		Test{"((a|b*)c)*", "abbbbbbccccbbccbcbcbcabc", false},
+		Test{"(ab|a)(bc|c)", "abc", true},
+		Test{"(ab|a)(bc|c)", "ab", false},
+		Test{"", "", true},
+		Test{"", "a", false},
+		Test{"a(|b)c", "ac", true},
+		Test{"a(|b)c", "abc", true},
 	}
 	for _, test := range tests {
 		nfa := RegexToNFA(test.Re)
@@ -33,3 +47,757 @@ func TestRegexCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		"a":             "a",
+		"(a|b)*blabla":  "(a|b)*blabla",
+		"((a))":         "a",
+		"a**":           "a*",
+		"(ab)c":         "abc",
+		"a|(b|c)":       "a|(b|c)",
+		"((a|b*)c)*":    "((a|b*)c)*",
+		"":              "()",
+		"a|":            "a|()",
+		`a\*`:           `a\*`,
+		`\(\|\)\\x`:     `\(\|\)\\x`,
+		`\a`:            "a",
+		"[c-ea-c]x":     "[a-e]x",
+		"[a]":           "a",
+		`[\]a-]`:        `[\-\]a]`,
+		"a.b":           "a.b",
+		"a{2}b{2,}":     "a{2}b{2,}",
+		"(ab){1,3}{2}":  "(ab){1,3}{2}",
+		"a{1}b{0}":      "a",
+		"a{0,}":         "a*",
+		`\{\.\[`:        `\{\.\[`,
+		"a{2":           `a\{2`,
+		"a{x}":          `a\{x\}`,
+		"a{1,x}":        `a\{1,x\}`,
+		"a{}{":          `a\{\}\{`,
+		"{a":            `\{a`,
+		"a{,2}":         `a\{,2\}`,
+		"[^a]b":         "[^a]b",
+		"[^b-dac]":      "[^a-d]",
+		`[^\^]`:         `[^\^]`,
+		`\x{41}\x{e9}`:  "Aé",
+		`[\x{0}-a]`:     `[\x{0}-a]`,
+		`\x{a}\x{7f}`:   `\x{a}\x{7f}`,
+		`[^\x{10FFFF}]`: `[\x{0}-\x{10fffe}]`,
+		`\x{zz}`:        `x\{zz\}`,
+		`\x{`:           `x\{`,
+	}
+	for re, expected := range tests {
+		node, err := Parse(re)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", re, err)
+			continue
+		}
+		if node.String() != expected {
+			t.Errorf("Parse(%q) gives %q, expected %q", re, node.String(), expected)
+		}
+	}
+	for _, re := range []string{"(a", "a)", "*a", "a|*", "(*)", `a\`,
+		"[ab", "[]", "[^]", `\x{110000}`, `[\x{99999999999999999999}]`, "[^\x00-\U0010FFFF]", "[b-a]", "{2}", "{3,2}", "a{3,2}", "a{99999999}", "a{99999999999999999999}"} {
+		if _, err := Parse(re); err == nil {
+			t.Errorf("Parse(%q) should fail", re)
+		}
+	}
+}
+
+func TestPartialDerivativeNFA(t *testing.T) {
+	type Test struct {
+		Re      string
+		Match   string
+		Matches bool
+	}
+	tests := []Test{
+		Test{"(a|b)*blabla", "abababblabla", true},
+		Test{"(a|b)*blabla", "abababblab", false},
+		Test{"(a|bb)*", "abbaaaabbbb", true},
+		Test{"(a|bb)*", "", true},
+		Test{"(a|bb)*", "abbab", false},
+		Test{"a|b|c", "b", true},
+		Test{"((a|b*)c)*", "accccccc", true},
+		Test{"((a|b*)c)*", "aaccccc", false},
+		Test{"((a|b*)c)*", "abbbbbbccccbbccbcbcbcabc", false},
+		Test{"(ab|a)(bc|c)", "abc", true},
+		Test{"(ab|a)(bc|c)", "abbc", true},
+		Test{"(ab|a)(bc|c)", "ac", true},
+		Test{"(ab|a)(bc|c)", "ab", false},
+	}
+	for _, test := range tests {
+		node, err := Parse(test.Re)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", test.Re, err)
+		}
+		nfa := PartialDerivativeNFA(node)
+		dfa := nfa.ToDFA()
+		dfa.Minimize()
+		if dfa.Check(test.Match) != test.Matches {
+			t.Errorf("Regex fails: %s with %s", test.Re, test.Match)
+			dfa.Print(os.Stderr)
+		}
+	}
+}
+
+func TestPartialDerivativeNFASize(t *testing.T) {
+	for _, re := range []string{"(a|b)*abb", "((a|b*)c)*", "a(bc|c)*"} {
+		node, _ := Parse(re)
+		characters := 0
+		for _, char := range re {
+			if char != '(' && char != ')' && char != '|' && char != '*' {
+				characters++
+			}
+		}
+		pd := PartialDerivativeNFA(node)
+		thompson := RegexToNFA(re)
+		if pd.NumStates > characters+1 {
+			t.Errorf("%s: %d states, expected at most %d", re, pd.NumStates, characters+1)
+		}
+		if pd.NumStates >= thompson.NumStates {
+			t.Errorf("%s: %d states, Thompson's NFA has %d", re, pd.NumStates, thompson.NumStates)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	r, err := Compile("(a|bb)*c")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !r.Match("abbac") || r.Match("abac") || r.String() != "(a|bb)*c" {
+		t.Errorf("Wrong compiled regex")
+	}
+	if r.Engine() != EngineBitParallel {
+		t.Errorf("Expected the bit-parallel engine, got %v", r.Engine())
+	}
+	if _, err := Compile("(a|b"); err == nil {
+		t.Errorf("Compile should fail on a syntax error")
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	// the DFA of (a|b)*a(a|b)(a|b)... needs 2^12 states, and the c's keep
+	// the expression out of the bit-parallel engine
+	re := "(a|b)*a" + strings.Repeat("(a|b)", 11) + strings.Repeat("c", 60)
+	limits := []dfa.Limits{
+		dfa.Limits{MaxNFAStates: 10},
+		dfa.Limits{MaxDFAStates: 1000},
+	}
+	for _, limit := range limits {
+		if _, err := CompileContext(context.Background(), re, limit); !errors.Is(err, dfa.ErrTooManyStates) {
+			t.Errorf("Expected ErrTooManyStates with %+v, got %v", limit, err)
+		}
+	}
+	_, err := CompileContext(context.Background(), re, dfa.Limits{MaxMemory: 100000})
+	if !errors.Is(err, dfa.ErrTooMuchMemory) {
+		t.Errorf("Expected ErrTooMuchMemory, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CompileContext(ctx, re, dfa.Limits{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	r, err := CompileContext(context.Background(), "(a|b)*abb", dfa.Limits{MaxNFAStates: 10, MaxDFAStates: 10, MaxMemory: 10000})
+	if err != nil {
+		t.Fatalf("Compiling within the limits failed: %v", err)
+	}
+	if !r.Match("babb") || r.Match("abba") {
+		t.Errorf("Wrong compiled regex")
+	}
+
+	// every engine keeps to the limits and gives up on a cancelled context
+	engines := map[string]Engine{
+		"foo|bar|baz":  EngineAhoCorasick,
+		"(a|b)*abb":    EngineBitParallel,
+		"[a-z]{1000}":  EngineCounting,
+		re:             EngineDFA,
+		"(a|b)*a{100}": EngineDFA,
+	}
+	for pattern, engine := range engines {
+		if r := MustCompile(pattern); r.Engine() != engine {
+			t.Errorf("%s: expected the %v engine, got %v", pattern, engine, r.Engine())
+		}
+		if _, err := CompileContext(ctx, pattern, dfa.Limits{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected context.Canceled, got %v", pattern, err)
+		}
+		if _, err := CompileContext(context.Background(), pattern, dfa.Limits{MaxNFAStates: 2}); !errors.Is(err, dfa.ErrTooManyStates) {
+			t.Errorf("%s: expected ErrTooManyStates, got %v", pattern, err)
+		}
+	}
+	// the DFA limits don't hold for the engines that need no DFA, even
+	// though the DFA would go over them
+	type Test struct {
+		Re     string
+		Engine Engine
+		Word   string
+	}
+	for _, test := range []Test{
+		Test{".*x.{50}", EngineBitParallel, "ax" + strings.Repeat("a", 50)},
+		Test{"(a|b)*a[ab]{300}", EngineCounting, strings.Repeat("a", 301)},
+	} {
+		r, err := CompileContext(context.Background(), test.Re, dfa.Limits{MaxDFAStates: 100000, MaxMemory: 1 << 20})
+		if err != nil {
+			t.Errorf("%s: compiling failed: %v", test.Re, err)
+			continue
+		}
+		if r.Engine() != test.Engine || !r.Match(test.Word) || r.Match(test.Word[1:len(test.Word)-1]) {
+			t.Errorf("%s: wrong compiled regex with the %v engine", test.Re, r.Engine())
+		}
+	}
+
+	// the Aho-Corasick automaton of a long alternation stops at the limit
+	keywords := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keywords = append(keywords, fmt.Sprintf("k%d", i))
+	}
+	if _, err := CompileContext(context.Background(), strings.Join(keywords, "|"), dfa.Limits{MaxDFAStates: 100}); !errors.Is(err, dfa.ErrTooManyStates) {
+		t.Errorf("Expected ErrTooManyStates, got %v", err)
+	}
+}
+
+func BenchmarkRegexToNFA(b *testing.B) {
+	re := strings.Repeat("(ab|c)*d", 500)
+	for i := 0; i < b.N; i++ {
+		RegexToNFA(re)
+	}
+}
+
+// words returns every word up to the given length over the alphabet
+func words(alphabet string, length int) []string {
+	res := []string{""}
+	last := []string{""}
+	for i := 0; i < length; i++ {
+		next := make([]string, 0)
+		for _, word := range last {
+			for _, char := range alphabet {
+				next = append(next, word+string(char))
+			}
+		}
+		res = append(res, next...)
+		last = next
+	}
+	return res
+}
+
+func TestToRegex(t *testing.T) {
+	patterns := []string{
+		"a",
+		"(a|b)*abb",
+		"((a|b*)c)*",
+		"(a|bb)*",
+		"a(|b)c",
+		"()",
+		`(\*|a\()*\|`,
+		"(ab|a)(bc|c)",
+		"a*b*a*",
+		"[a-b]c*",
+		"([ab]|c)*[b-c]",
+		"[(-*]a|[b-|]",
+		"a.b",
+		".*a",
+		"[^a]b*",
+		"(.|a)c",
+		`[^(]*\(`,
+		"(a|[^a]).",
+		"[^ab]c",
+		"b.[^a]",
+		"a.*b",
+		`\x{0}[\x{1}-\x{10ffff}]`,
+	}
+	for _, re := range patterns {
+		original := MustCompile(re)
+		d := original.DFA()
+		fromDFA, ok := d.ToRegex()
+		if !ok {
+			t.Errorf("DFA of %s gives no regex", re)
+			continue
+		}
+		n := RegexToNFA(re)
+		fromNFA, ok := n.ToRegex()
+		if !ok {
+			t.Errorf("NFA of %s gives no regex", re)
+			continue
+		}
+		for _, res := range []string{fromDFA, fromNFA} {
+			compiled, err := Compile(res)
+			if err != nil {
+				t.Errorf("Regex %q from %s doesn't compile: %v", res, re, err)
+				continue
+			}
+			for _, word := range words(`abc*(|`, 5) {
+				if compiled.Match(word) != original.Match(word) {
+					t.Errorf("Regex %q from %s differs at: %q", res, re, word)
+					break
+				}
+			}
+			if ok, word := nfa.Equivalent(RegexToNFA(res), n); !ok {
+				t.Errorf("Regex %q from %s isn't equivalent, at: %q", res, re, word)
+			}
+		}
+	}
+
+	never := RegexToNFA("a")
+	never.FinalStates = []int{}
+	if _, ok := never.ToRegex(); ok {
+		t.Errorf("An NFA that accepts nothing shouldn't give a regex")
+	}
+	d := never.ToDFA()
+	if _, ok := d.ToRegex(); ok {
+		t.Errorf("A DFA that accepts nothing shouldn't give a regex")
+	}
+}
+
+func TestRegexSet(t *testing.T) {
+	patterns := []string{
+		"(a|b)*c",
+		"a*c",
+		"ac",
+		"(ab|a)(bc|c)",
+		"b*",
+		"x",
+	}
+	set, err := CompileSet(patterns)
+	if err != nil {
+		t.Fatalf("CompileSet failed: %v", err)
+	}
+	if set.Len() != len(patterns) {
+		t.Errorf("Wrong number of patterns: %d", set.Len())
+	}
+	compiled := make([]*Regexp, len(patterns))
+	for i, re := range patterns {
+		compiled[i] = MustCompile(re)
+	}
+	for _, word := range words("abcx", 5) {
+		expected := make([]int, 0)
+		for i, r := range compiled {
+			if r.Match(word) {
+				expected = append(expected, i)
+			}
+		}
+		if got := set.Match(word); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("Wrong patterns for %q: %v, expected %v", word, got, expected)
+		}
+	}
+
+	if _, err := CompileSet([]string{"a", "(b"}); err == nil {
+		t.Errorf("CompileSet should fail on a syntax error")
+	}
+	empty, _ := CompileSet(nil)
+	if len(empty.Match("")) != 0 {
+		t.Errorf("An empty set shouldn't match anything")
+	}
+}
+
+func TestRegexSetMany(t *testing.T) {
+	patterns := make([]string, 500)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("route%d(/x)*", i)
+	}
+	set, err := CompileSet(patterns)
+	if err != nil {
+		t.Fatalf("CompileSet failed: %v", err)
+	}
+	if got := set.Match("route42/x/x"); fmt.Sprint(got) != "[42]" {
+		t.Errorf("Wrong patterns: %v", got)
+	}
+	if got := set.Match("route4"); fmt.Sprint(got) != "[4]" {
+		t.Errorf("Wrong patterns: %v", got)
+	}
+	if got := set.Match("route500"); len(got) != 0 {
+		t.Errorf("Wrong patterns: %v", got)
+	}
+}
+
+func TestBitParallel(t *testing.T) {
+	tests := []string{
+		"(a|bb)*c",
+		"(ab|a)(bc|c)",
+		"a*(b|())a",
+		"((a|b)*|c)*ab",
+		"",
+		"()*",
+		"(a|b)*a" + strings.Repeat("(a|b)", 30),
+	}
+	for _, re := range tests {
+		r := MustCompile(re)
+		if r.Engine() != EngineBitParallel {
+			t.Errorf("%s: expected the bit-parallel engine, got %v", re, r.Engine())
+			continue
+		}
+		n := RegexToNFA(re)
+		for _, word := range words("abc", 6) {
+			if r.Match(word) != n.Match(word) {
+				t.Errorf("%s: Match(%q) gives %v", re, word, r.Match(word))
+			}
+		}
+	}
+
+	long := MustCompile(strings.Repeat("(a|b)", 33))
+	if long.Engine() != EngineDFA {
+		t.Errorf("Expected the DFA engine for 66 characters, got %v", long.Engine())
+	}
+	if !long.Match(strings.Repeat("a", 33)) || long.Match(strings.Repeat("a", 32)) {
+		t.Errorf("Wrong compiled regex")
+	}
+}
+
+func TestAhoCorasick(t *testing.T) {
+	keywords := make([]string, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		keywords = append(keywords, fmt.Sprintf("k%dx", i))
+	}
+	r := MustCompile(strings.Join(keywords, "|"))
+	if r.Engine() != EngineAhoCorasick {
+		t.Fatalf("Expected the Aho-Corasick engine, got %v", r.Engine())
+	}
+	if !r.Match("k1234x") || r.Match("k1234") || r.Match("k1234xk1x") {
+		t.Errorf("Wrong compiled regex")
+	}
+	text := "ak12k199xk7xk2000x"
+	expected := [][]int{[]int{4, 9}, []int{9, 12}}
+	if res := r.FindAll(text); !reflect.DeepEqual(res, expected) {
+		t.Errorf("FindAll(%q) gives %v, expected %v", text, res, expected)
+	}
+
+	for _, re := range []string{"a|(bc|d)", "abc", "a|"} {
+		expected := EngineAhoCorasick
+		if re == "a|" {
+			expected = EngineBitParallel
+		}
+		if engine := MustCompile(re).Engine(); engine != expected {
+			t.Errorf("%s: expected the %v engine, got %v", re, expected, engine)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []string{
+		"ab|b|abc",
+		"a*",
+		"(a|b)*c",
+		"b(a|c)*b",
+		"b(a|c)*" + strings.Repeat("(a|c)", 32),
+		"b(a|c)*" + strings.Repeat("(a|c)", 2),
+		"ab(a|c)*",
+		"(a|c)*bc(a|b)*",
+		"(abc|cbca)*a",
+		"(abc|cbcab)(a|b)*",
+		"(a|c)bc(a|b)",
+		"(a|c){0,2}bc",
+		"[ac]{1,2}b(a|cc)",
+	}
+	for _, re := range tests {
+		r := MustCompile(re)
+		for _, text := range words("abc", 5) {
+			// the leftmost-longest match, by trying every substring
+			var expected []int
+			for start := 0; start <= len(text) && expected == nil; start++ {
+				for end := len(text); end >= start; end-- {
+					if r.Match(text[start:end]) {
+						expected = []int{start, end}
+						break
+					}
+				}
+			}
+			if res := r.Find(text); !reflect.DeepEqual(res, expected) {
+				t.Errorf("%s (%v): Find(%q) gives %v, expected %v", re, r.Engine(), text, res, expected)
+			}
+		}
+	}
+
+	all := MustCompile("a*").FindAll("baaab")
+	expected := [][]int{[]int{0, 0}, []int{1, 4}, []int{5, 5}}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("FindAll gives %v, expected %v", all, expected)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	type Test struct {
+		Re       string
+		Prefix   string
+		Complete bool
+		Factor   string
+	}
+	tests := []Test{
+		Test{"GET /(a|b)*", "GET /", false, "GET /"},
+		Test{"(a|b)*error(a|b)*", "", false, "error"},
+		Test{"abc", "abc", true, "abc"},
+		Test{"abc|abc", "abc", true, "abc"},
+		Test{"abd|abc", "ab", false, "ab"},
+		Test{"(xhelloy|yellowz)*", "", false, ""},
+		Test{"a(xhelloy|yellowz)b", "a", false, "ello"},
+		Test{"(a|b)*(cd|ed)", "", false, "d"},
+		Test{"a*ăș(b|șt)", "", false, "ăș"},
+		Test{"", "", true, ""},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.Re)
+		prefix, complete := r.LiteralPrefix()
+		if prefix != test.Prefix || complete != test.Complete || r.RequiredFactor() != test.Factor {
+			t.Errorf("%s: got prefix %q (%v) and factor %q, expected %q (%v) and %q",
+				test.Re, prefix, complete, r.RequiredFactor(), test.Prefix, test.Complete, test.Factor)
+		}
+	}
+
+	// nested repetitions aren't expanded in full, and their widths don't
+	// overflow
+	for re, width := range map[string]int{"(a{10000}){10000}": 100000000, "(é{1048576}){1048576}b": maxWidth} {
+		node, err := Parse(re)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", re, err)
+		}
+		info := literalsOf(node)
+		if info.exact || len(info.prefix) > maxLiteral || len(info.suffix) > maxLiteral ||
+			len(info.factor) > maxLiteral || info.width != width || !utf8.ValidString(info.prefix) {
+			t.Errorf("%s: got prefix of %d bytes (%v), suffix of %d, factor of %d and width %d",
+				re, len(info.prefix), info.exact, len(info.suffix), len(info.factor), info.width)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	type Test struct {
+		Re     string
+		Text   string
+		Start  int
+		Factor int
+	}
+	text := strings.Repeat("x", 100) + "aberror"
+	tests := []Test{
+		// a match is at most 7 bytes long and has to end with the factor
+		Test{"[a-z]{2}error", text, 100, 102},
+		Test{"[a-z]{0,2}error", text, 100, 102},
+		// a match can be as long as it wants
+		Test{"[a-z]*error", text, 0, 102},
+		Test{"[a-z]{2}warning", text, -1, -1},
+		Test{"ab[eor]*", text, 100, -1},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.Re)
+		start, factor := r.skip(test.Text, 0, -1)
+		if start != test.Start || (start >= 0 && factor != test.Factor) {
+			t.Errorf("%s: skips to %d with the factor at %d, expected %d and %d",
+				test.Re, start, factor, test.Start, test.Factor)
+		}
+		if start >= 0 && !reflect.DeepEqual(r.Find(test.Text), []int{test.Start, len(test.Text)}) {
+			t.Errorf("%s: Find gives %v", test.Re, r.Find(test.Text))
+		}
+	}
+}
+
+func BenchmarkFindFactor(b *testing.B) {
+	r := MustCompile("(a|b|c|d|e)*error(a|b|c|d|e)*")
+	text := strings.Repeat("a line without the word we want ", 1000)
+	for i := 0; i < b.N; i++ {
+		r.Find(text)
+	}
+}
+
+func TestCounting(t *testing.T) {
+	type Test struct {
+		Re     string
+		Engine Engine
+	}
+	tests := []Test{
+		Test{"[a-c]{2,4}b", EngineBitParallel},
+		Test{"a.b|.c", EngineBitParallel},
+		Test{"(a|bc){2,}", EngineBitParallel},
+		Test{"(ab|a){0,3}(bc|c)", EngineBitParallel},
+		Test{"(a|b)*a[ab]{3}c{100}", EngineDFA},
+		Test{"a.{70}b", EngineDFA},
+		Test{"(a|b)*a[ab]{10,300}", EngineCounting},
+		Test{"(a*b){2,200}(a|c){3}", EngineCounting},
+		Test{"(a|b|c)*(a{1,2}|c){100,}", EngineCounting},
+		// short enough to unroll, but their DFAs explode
+		Test{"[ab]*a[ab]{70}", EngineCounting},
+		Test{"[a-z]*x[a-z]{100}", EngineCounting},
+		Test{"((([^a][ab][ab]){5,10})*[ab](([ab]){0,0})*(b[^é]é|)((c)*|)){3,6}", EngineCounting},
+	}
+	for _, test := range tests {
+		r := MustCompile(test.Re)
+		if r.Engine() != test.Engine {
+			t.Errorf("%s: expected the %v engine, got %v", test.Re, test.Engine, r.Engine())
+		}
+		// the dot only stands for the letters here
+		node, _ := Parse(strings.Replace(test.Re, ".", "[a-c]", -1))
+		n := PartialDerivativeNFA(node)
+		c := counting{node}
+		for _, word := range words("abc", 6) {
+			if r.Match(word) != n.Match(word) || c.match(word) != n.Match(word) {
+				t.Errorf("%s: Match(%q) gives %v", test.Re, word, r.Match(word))
+			}
+		}
+	}
+}
+
+func TestCountingLarge(t *testing.T) {
+	r := MustCompile("[a-z]{1000}")
+	if r.Engine() != EngineCounting {
+		t.Errorf("Expected the counting engine, got %v", r.Engine())
+	}
+	if !r.Match(strings.Repeat("ab", 500)) || r.Match(strings.Repeat("ab", 499)) || r.Match(strings.Repeat("a", 999)+"A") {
+		t.Errorf("Wrong compiled regex")
+	}
+
+	// the x has to be 51 characters from the end
+	r = MustCompile(".*x.{50}")
+	word := strings.Repeat("yx", 200) + "x" + strings.Repeat("y", 50)
+	if !r.Match(word) || r.Match(word+"y") || r.Match(word[1:]) == false {
+		t.Errorf("Wrong compiled regex")
+	}
+	if r.Match(strings.Repeat("xy", 20)) {
+		t.Errorf("A word shorter than 51 characters shouldn't match")
+	}
+	found := r.Find("yyyx" + strings.Repeat("y", 60))
+	if !reflect.DeepEqual(found, []int{0, 54}) {
+		t.Errorf("Find gives %v", found)
+	}
+}
+
+func TestAnyChar(t *testing.T) {
+	patterns := []string{"a.b|.c", ".*x.{3}", "[ab].*", "(.a|b)*", "a.?"}
+	compiled := make([]*Regexp, len(patterns))
+	for i, re := range patterns {
+		r := MustCompile(re)
+		compiled[i] = r
+		node, _ := Parse(re)
+		d, thompson, antimirov := r.DFA(), ThompsonNFA(node), PartialDerivativeNFA(node)
+		if d.Alphabet[0] != dfa.Other {
+			t.Errorf("%s: the alphabet should start with Other: %q", re, d.Alphabet)
+		}
+		for _, word := range words("abxλ", 5) {
+			res := r.Match(word)
+			if d.Check(word) != res || thompson.Match(word) != res || antimirov.Match(word) != res {
+				t.Errorf("%s: the automata disagree on %q", re, word)
+			}
+		}
+	}
+
+	set, err := CompileSet(patterns)
+	if err != nil {
+		t.Fatalf("CompileSet failed: %v", err)
+	}
+	for _, word := range words("abxλ", 5) {
+		expected := make([]int, 0)
+		for i, r := range compiled {
+			if r.Match(word) {
+				expected = append(expected, i)
+			}
+		}
+		if got := set.Match(word); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("Wrong patterns for %q: %v, expected %v", word, got, expected)
+		}
+	}
+
+	// the alphabet of [^a]|a holds every valid character, leaving nothing
+	// for Other to stand for
+	if ok, word := nfa.Equivalent(RegexToNFA("."), RegexToNFA("[^a]|a")); !ok {
+		t.Errorf(". and [^a]|a should be equivalent, differ at %q", word)
+	}
+}
+
+func TestWideClass(t *testing.T) {
+	// every character, and those in the same range of the alphabet, share
+	// a single transition
+	every := "[\x00-\U0010FFFF]"
+	node, _ := Parse(every + "{70}")
+	r := MustCompile(every + "{70}")
+	if r.Engine() != EngineDFA {
+		t.Fatalf("Expected the DFA engine, got %v", r.Engine())
+	}
+	d := r.DFA()
+	if len(d.Alphabet) != 2 || d.NumTransitions != 70 {
+		t.Errorf("Expected 2 symbols and 70 transitions, got %q and %d", d.Alphabet, d.NumTransitions)
+	}
+	thompson, antimirov := ThompsonNFA(node), PartialDerivativeNFA(node)
+	for _, word := range []string{strings.Repeat("é", 70), strings.Repeat("\U0010FFFF", 70), strings.Repeat("a", 69)} {
+		res := len([]rune(word)) == 70
+		if r.Match(word) != res || thompson.Match(word) != res || antimirov.Match(word) != res {
+			t.Errorf("The automata disagree on %q", word)
+		}
+	}
+
+	node, _ = Parse("[a-y]x[\u0100-\U0010FFFF]*")
+	alphabet := alphabetOf(node)
+	expected := []rune{nfa.Other, 'a', 'x', 'y', 0x100}
+	if !reflect.DeepEqual(alphabet.symbols, expected) || alphabet.ends['a'] != 'w' || alphabet.ends[0x100] != 0x10FFFF {
+		t.Errorf("Wrong alphabet: %q, %q", alphabet.symbols, alphabet.ends)
+	}
+	antimirov = PartialDerivativeNFA(node)
+	if antimirov.NumTransitions != 5 {
+		t.Errorf("Expected 5 transitions, got %d", antimirov.NumTransitions)
+	}
+	for word, res := range map[string]bool{"axĀ": true, "yx": true, "xx": true, "zx": false, "ax\U0010FFFFa": false} {
+		if antimirov.Match(word) != res || MustCompile("[a-y]x[\u0100-\U0010FFFF]*").Match(word) != res {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+}
+
+func TestAnyCharMerge(t *testing.T) {
+	type Test struct {
+		NFA      nfa.NFA
+		Accepted []string
+		Rejected []string
+	}
+	tests := map[string]Test{
+		".a":         Test{RegexToNFA(".a"), []string{"aa", "ba", "xa"}, []string{"a", "ab", "aax"}},
+		"a.":         Test{RegexToNFA("a."), []string{"aa", "ab", "ax"}, []string{"a", "ba", "aax"}},
+		"(.|b)c":     Test{RegexToNFA("(.|b)c"), []string{"bc", "cc", "xc"}, []string{"c", "bb", "bcc"}},
+		".b":         Test{nfa.Concat(RegexToNFA("."), nfa.Rune('b')), []string{"xb", "bb"}, []string{"b", "xc"}},
+		"a(.)":       Test{nfa.Concat(nfa.Rune('a'), RegexToNFA(".")), []string{"aa", "ax"}, []string{"a", "xa"}},
+		"(.)(.a)":    Test{nfa.Concat(RegexToNFA("."), RegexToNFA(".a")), []string{"xya", "aaa"}, []string{"ya", "xyb"}},
+		".|ab":       Test{nfa.Either(RegexToNFA("."), nfa.Literal("ab")), []string{"a", "b", "x", "ab"}, []string{"", "ax", "xb"}},
+		"ab|.":       Test{nfa.Either(nfa.Literal("ab"), RegexToNFA(".")), []string{"a", "b", "x", "ab"}, []string{"", "ax", "xb"}},
+		"(.|ab)*":    Test{nfa.Star(nfa.Either(RegexToNFA("."), RegexToNFA("ab"))), []string{"", "abx", "xxab"}, []string{}},
+		"(.bc){1,2}": Test{nfa.Repeat(nfa.Concat(RegexToNFA(".b"), RegexToNFA("c")), 1, 2), []string{"abc", "xbcbbc"}, []string{"ab", "xbcx"}},
+	}
+	for name, test := range tests {
+		d := test.NFA.ToDFA()
+		for _, word := range test.Accepted {
+			if !test.NFA.Match(word) || !d.Check(word) {
+				t.Errorf("%s should accept %q", name, word)
+			}
+		}
+		for _, word := range test.Rejected {
+			if test.NFA.Match(word) || d.Check(word) {
+				t.Errorf("%s shouldn't accept %q", name, word)
+			}
+		}
+	}
+}
+
+func TestComplement(t *testing.T) {
+	// the file names that don't match any of the patterns
+	r := MustCompile(`.*\.go|.*\.md|Makefile`)
+	d := r.DFA()
+	denied := d.Complement()
+	denied.Minimize()
+	tests := map[string]bool{"main.go": false, "README.md": false, "Makefile": false,
+		"main.c": true, "go": true, ".gox": true, "": true, "日本.txt": true}
+	for word, res := range tests {
+		if denied.Check(word) != res {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+}
+
+func TestProduct(t *testing.T) {
+	// the alphabets differ, so each DFA reads the other's character
+	// through Other
+	a, b := MustCompile(".*a.*").DFA(), MustCompile(".*b.*").DFA()
+	both := dfa.Intersect(a, b, true)
+	either := dfa.Union(a, b, true)
+	only := dfa.Difference(a, b, true)
+	for _, word := range words("abz", 5) {
+		x, y := a.Check(word), b.Check(word)
+		if both.Check(word) != (x && y) || either.Check(word) != (x || y) || only.Check(word) != (x && !y) {
+			t.Errorf("Wrong answer at: %q", word)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package regex
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Find returns the byte offsets [start, end] of the leftmost-longest match
+// of the regular expression in the text, or nil if nothing matches.
+func (r *Regexp) Find(text string) []int {
+	return r.find(text, 0)
+}
+
+// FindAll returns the byte offsets of the successive non-overlapping
+// leftmost-longest matches in the text. As with the regexp package, an
+// empty match right after another match is skipped.
+func (r *Regexp) FindAll(text string) [][]int {
+	res := make([][]int, 0)
+	last := -1
+	for from := 0; from <= len(text); {
+		match := r.find(text, from)
+		if match == nil {
+			break
+		}
+		if match[0] != match[1] || match[0] != last {
+			res = append(res, match)
+		}
+		last = match[1]
+		from = match[1]
+		if match[0] == match[1] {
+			if from == len(text) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(text[from:])
+			from += size
+		}
+	}
+	return res
+}
+
+func (r *Regexp) find(text string, from int) []int {
+	if r.engine == EngineAhoCorasick {
+		return r.words.Find(text, from)
+	}
+	// the offset of the next occurrence of the required factor
+	factor := -1
+	for start := from; start <= len(text); start++ {
+		if start, factor = r.skip(text, start, factor); start < 0 {
+			return nil
+		}
+		if start < len(text) && !utf8.RuneStart(text[start]) {
+			continue
+		}
+		if end := r.longest(text, start); end >= 0 {
+			return []int{start, end}
+		}
+	}
+	return nil
+}
+
+// skip returns the first offset from start on where a match can begin, as
+// far as the literal prefix and the required factor tell, or -1 if no match
+// can. factor is the offset of an occurrence of the factor found before, and
+// the one of the first occurrence after start is returned along.
+//
+// A match has to start with the prefix, and has to contain the factor, so it
+// can only start before the next occurrence of the factor if it is long
+// enough to reach its end.
+func (r *Regexp) skip(text string, start, factor int) (int, int) {
+	if r.literal.prefix != "" {
+		i := strings.Index(text[start:], r.literal.prefix)
+		if i < 0 {
+			return -1, factor
+		}
+		return start + i, factor
+	}
+	if r.literal.factor == "" {
+		return start, factor
+	}
+	if factor < start {
+		i := strings.Index(text[start:], r.literal.factor)
+		if i < 0 {
+			return -1, factor
+		}
+		factor = start + i
+	}
+	if r.literal.width >= 0 {
+		if first := factor + len(r.literal.factor) - r.literal.width; first > start {
+			start = first
+		}
+	}
+	return start, factor
+}
+
+// longest returns the end of the longest match starting at the given
+// offset of the text, or -1 if there is none
+func (r *Regexp) longest(text string, start int) int {
+	switch r.engine {
+	case EngineBitParallel:
+		return r.bits.longest(text, start)
+	case EngineCounting:
+		return r.counts.longest(text, start)
+	}
+	d := r.DFA()
+	res := -1
+	state := d.EntryState
+	if d.IsFinal(state) {
+		res = start
+	}
+	for i, char := range text[start:] {
+		next, ok := d.Next(state, char)
+		if !ok {
+			break
+		}
+		state = next
+		if d.IsFinal(state) {
+			_, size := utf8.DecodeRuneInString(text[start+i:])
+			res = start + i + size
+		}
+	}
+	return res
+}
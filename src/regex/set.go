@@ -0,0 +1,65 @@
+package regex
+
+import (
+	"dfa"
+	"fmt"
+	"nfa"
+)
+
+// RegexSet matches words against many regular expressions at once, in a
+// single pass over the word
+type RegexSet struct {
+	patterns []string
+	dfa      dfa.DFA
+}
+
+// CompileSet compiles a set of regular expressions into a single minimized
+// DFA, whose final states are labelled with the indices of the patterns
+// they accept.
+func CompileSet(patterns []string) (*RegexSet, error) {
+	nodes := make([]*Node, len(patterns))
+	for i, re := range patterns {
+		node, err := Parse(re)
+		if err != nil {
+			return nil, fmt.Errorf("Pattern %d: %v", i, err)
+		}
+		nodes[i] = node
+	}
+	b := nfa.NewBuilder()
+	alphabet := alphabetOf(nodes...)
+	fragments := make([]nfa.Fragment, len(patterns))
+	for i, node := range nodes {
+		fragments[i] = thompson(b, node, alphabet)
+	}
+	n := b.BuildLabelled(fragments)
+	n.Alphabet, n.Ends = alphabet.symbols, alphabet.ends
+	res := &RegexSet{patterns: patterns, dfa: n.ToDFA()}
+	res.dfa.Minimize()
+	return res, nil
+}
+
+// Match returns, in increasing order, the indices of the patterns that
+// match the whole word
+func (s *RegexSet) Match(word string) []int {
+	state := s.dfa.EntryState
+	for _, char := range word {
+		next, ok := s.dfa.Next(state, char)
+		if !ok {
+			return nil
+		}
+		state = next
+	}
+	res := make([]int, len(s.dfa.Labels[state]))
+	copy(res, s.dfa.Labels[state])
+	return res
+}
+
+// Len returns the number of patterns in the set
+func (s *RegexSet) Len() int {
+	return len(s.patterns)
+}
+
+// Patterns returns the regular expressions the set was compiled from
+func (s *RegexSet) Patterns() []string {
+	return s.patterns
+}
@@ -0,0 +1,50 @@
+package stateset
+
+// Set is a set of states numbered from 0 to a fixed maximum, which can be
+// cleared in constant time and remembers the order in which states were
+// added. See Briggs and Torczon, "An efficient representation for sparse
+// sets".
+type Set struct {
+	dense  []int
+	sparse []int
+}
+
+// New returns an empty set that can hold the states 0 to size-1.
+func New(size int) *Set {
+	return &Set{
+		dense:  make([]int, 0, size),
+		sparse: make([]int, size),
+	}
+}
+
+// Contains returns true if the state is in the set.
+func (s *Set) Contains(state int) bool {
+	i := s.sparse[state]
+	return i < len(s.dense) && s.dense[i] == state
+}
+
+// Add puts a state in the set. It returns false if it was already there.
+func (s *Set) Add(state int) bool {
+	if s.Contains(state) {
+		return false
+	}
+	s.sparse[state] = len(s.dense)
+	s.dense = append(s.dense, state)
+	return true
+}
+
+// Len returns the number of states in the set.
+func (s *Set) Len() int {
+	return len(s.dense)
+}
+
+// Elements returns the states in the order they were added. The slice is
+// only valid until the set is changed.
+func (s *Set) Elements() []int {
+	return s.dense
+}
+
+// Clear removes all the states from the set.
+func (s *Set) Clear() {
+	s.dense = s.dense[:0]
+}
@@ -0,0 +1,28 @@
+package stateset
+
+import "testing"
+
+func TestSet(t *testing.T) {
+	s := New(10)
+	if !s.Add(3) || !s.Add(7) || !s.Add(0) {
+		t.Fatalf("Adding new states should succeed")
+	}
+	if s.Add(7) {
+		t.Errorf("Adding a state twice should fail")
+	}
+	if s.Len() != 3 || !s.Contains(3) || !s.Contains(0) || s.Contains(5) {
+		t.Errorf("Wrong contents: %v", s.Elements())
+	}
+	elements := s.Elements()
+	if elements[0] != 3 || elements[1] != 7 || elements[2] != 0 {
+		t.Errorf("Wrong order: %v", elements)
+	}
+	s.Clear()
+	if s.Len() != 0 || s.Contains(3) {
+		t.Errorf("Set not empty after Clear: %v", s.Elements())
+	}
+	s.Add(5)
+	if s.Contains(3) || !s.Contains(5) {
+		t.Errorf("Wrong contents after Clear: %v", s.Elements())
+	}
+}